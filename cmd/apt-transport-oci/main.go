@@ -0,0 +1,196 @@
+// apt-transport-oci is the administration command of apt-transport-oci.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/dockerconfigresolver"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ledger"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/method"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+const usage = `Usage: apt-transport-oci COMMAND
+
+Commands:
+  ledger list [-dir DIR]                 List the digests recorded in the ledger
+  ledger approve [-dir DIR] REF DIGEST   Approve REF to resolve to DIGEST (e.g., a legitimate rollback)
+  ledger forget [-dir DIR] REF           Remove REF from the ledger
+  sources [-plain-http] REF              Print the sources entry of REF, from its repository config
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("no command was specified")
+	}
+	switch args[0] {
+	case "ledger":
+		return ledgerCommand(args[1:])
+	case "sources":
+		return sourcesCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func ledgerCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("no subcommand was specified")
+	}
+	fs := flag.NewFlagSet("ledger "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", ledger.DefaultDir, "ledger directory (Acquire::oci::Ledger::Dir)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	l := ledger.New(*dir)
+	switch args[0] {
+	case "list":
+		if fs.NArg() != 0 {
+			return errors.New("ledger list takes no argument")
+		}
+		return ledgerList(l)
+	case "approve":
+		if fs.NArg() != 2 {
+			return errors.New("ledger approve takes REF and DIGEST")
+		}
+		ref, err := normalizeRef(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		return l.Approve(ref, dgst)
+	case "forget":
+		if fs.NArg() != 1 {
+			return errors.New("ledger forget takes REF")
+		}
+		ref, err := normalizeRef(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return l.Forget(ref)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+}
+
+// normalizeRef normalizes the reference in the same way as the method, e.g., "debian" -> "docker.io/library/debian:latest".
+func normalizeRef(s string) (string, error) {
+	named, err := refdocker.ParseDockerRef(s)
+	if err != nil {
+		return "", err
+	}
+	return named.String(), nil
+}
+
+func ledgerList(l *ledger.Ledger) error {
+	entries, err := l.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "REF\tDIGEST\tCREATED\tLAST SEEN\tSTATUS")
+	for _, e := range entries {
+		for _, rec := range e.Records {
+			var status []string
+			if rec.Digest == e.Current {
+				status = append(status, "current")
+			}
+			if rec.Approved {
+				status = append(status, "approved")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Ref, rec.Digest, formatTime(rec.Created), formatTime(rec.LastSeen), joinOrDash(status))
+		}
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func joinOrDash(ss []string) string {
+	if len(ss) == 0 {
+		return "-"
+	}
+	return strings.Join(ss, ",")
+}
+
+func sourcesCommand(args []string) error {
+	fs := flag.NewFlagSet("sources", flag.ExitOnError)
+	hostsDir := fs.String("hosts-dir", method.DefaultHostsDir, "hosts directory (Acquire::oci::HostsDir)")
+	plainHTTP := fs.Bool("plain-http", false, "use plain HTTP (oci+http://)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("sources takes REF")
+	}
+	named, err := refdocker.ParseDockerRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	resolver, err := dockerconfigresolver.New(refdocker.Domain(named),
+		dockerconfigresolver.WithPlainHTTP(*plainHTTP),
+		dockerconfigresolver.WithRepository(refdocker.Path(named)),
+		dockerconfigresolver.WithHostsDir(*hostsDir),
+		dockerconfigresolver.WithProxy(http.ProxyFromEnvironment),
+	)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	name, rootDesc, err := resolver.Resolve(ctx, named.String())
+	if err != nil {
+		return err
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return err
+	}
+	rc, err := method.ReadRepoConfig(ctx, fetcher, rootDesc)
+	if err != nil {
+		return err
+	}
+	if rc == nil {
+		return fmt.Errorf("%q has no repository config (%s)", fs.Arg(0), repoconfig.MediaType)
+	}
+	scheme := "oci"
+	if *plainHTTP {
+		scheme = "oci+http"
+	}
+	if rc.Origin != "" || rc.Label != "" {
+		fmt.Printf("# Origin: %s, Label: %s\n", rc.Origin, rc.Label)
+	}
+	fmt.Print(rc.Sources(scheme + "://" + fs.Arg(0)))
+	return nil
+}
@@ -194,6 +194,10 @@ func (r *MessageReader) ReadLine() (*Message, error) {
 	key := strings.TrimSpace(parts[0])
 	value := strings.TrimSpace(parts[1])
 
+	if old, ok := r.message.Fields[key]; ok {
+		// Repeated fields (e.g., "Config-Item") are joined with "\n"
+		value = old + "\n" + value
+	}
 	r.message.Fields[key] = value
 	return nil, nil
 }
@@ -255,6 +259,8 @@ func (r *MessageReader) commitMessage(newmsg *Message) *Message {
 }
 
 // MessageWriter is a wrapper around an io.Writer which writes APT messages.
+//
+// The field values are folded into a single line with fieldValue.
 type MessageWriter struct {
 	w io.Writer
 }
@@ -269,10 +275,10 @@ func NewMessageWriter(w io.Writer) *MessageWriter {
 // This method is less efficient than the dedicated message functions, as it
 // has to format every part of the message.
 func (mw *MessageWriter) WriteMessage(msg *Message) {
-	fmt.Fprintf(mw.w, "%d %s\n", msg.StatusCode, msg.Description)
+	fmt.Fprintf(mw.w, "%d %s\n", msg.StatusCode, fieldValue(msg.Description))
 	for k, v := range msg.Fields {
 		if k != "" && v != "" {
-			fmt.Fprintf(mw.w, "%s: %s\n", k, v)
+			fmt.Fprintf(mw.w, "%s: %s\n", fieldValue(k), fieldValue(v))
 		}
 	}
 	mw.w.Write([]byte("\n"))
@@ -283,7 +289,7 @@ func (mw *MessageWriter) WriteMessage(msg *Message) {
 // Version must be non-empty. caps may be 0 for no capabilities, though
 // it probably should at least be CapSendConfig (or CapDefault)
 func (mw *MessageWriter) Capabilities(version string, caps CapFlags) {
-	fmt.Fprintf(mw.w, "100 Capabilities\nVersion: %s\n", version)
+	fmt.Fprintf(mw.w, "100 Capabilities\nVersion: %s\n", fieldValue(version))
 	if caps&CapSendConfig != 0 {
 		mw.w.Write([]byte("Send-Config: true\n"))
 	}
@@ -310,7 +316,7 @@ func (mw *MessageWriter) Capabilities(version string, caps CapFlags) {
 
 // Log writes a '101 Log' message.
 func (mw *MessageWriter) Log(msg string) {
-	fmt.Fprintf(mw.w, "101 Log\nMessage: %s\n\n", msg)
+	fmt.Fprintf(mw.w, "101 Log\nMessage: %s\n\n", fieldValue(msg))
 }
 
 // Logf writes a '101 Log' message and formats the arguments into it.
@@ -320,7 +326,7 @@ func (mw *MessageWriter) Logf(fmtspec string, args ...interface{}) {
 
 // Status writes a '102 status' message.
 func (mw *MessageWriter) Status(msg string) {
-	fmt.Fprintf(mw.w, "102 Status\nMessage: %s\n\n", msg)
+	fmt.Fprintf(mw.w, "102 Status\nMessage: %s\n\n", fieldValue(msg))
 }
 
 // Statusf writes a '102 status' message and formats the arguments into if.
@@ -329,20 +335,22 @@ func (mw *MessageWriter) Statusf(fmtspec string, args ...interface{}) {
 }
 
 // Redirect writes a '103 Redirect' message
-func (mw *MessageWriter) Redirect(uri, newURI, altURIs string, usedMirror bool) {
-	fmt.Fprintf(mw.w, "103 Redirect\nURI: %s\nNew-URI: %s\n", uri, newURI)
-	if usedMirror {
-		mw.w.Write([]byte("UsedMirror: true\n"))
+//
+// usedMirror is the URI of the mirror, or "" when no mirror is used.
+func (mw *MessageWriter) Redirect(uri, newURI, altURIs, usedMirror string) {
+	fmt.Fprintf(mw.w, "103 Redirect\nURI: %s\nNew-URI: %s\n", fieldValue(uri), fieldValue(newURI))
+	if usedMirror != "" {
+		fmt.Fprintf(mw.w, "UsedMirror: %s\n", fieldValue(usedMirror))
 	}
 	if altURIs != "" {
-		fmt.Fprintf(mw.w, "Alt-URIs: %s\n", altURIs)
+		fmt.Fprintf(mw.w, "Alt-URIs: %s\n", fieldValue(altURIs))
 	}
 	mw.w.Write([]byte("\n"))
 }
 
 // Warning writes a '104 Warning' message.
 func (mw *MessageWriter) Warning(msg string) {
-	fmt.Fprintf(mw.w, "104 Warning\nMessage: %s\n\n", msg)
+	fmt.Fprintf(mw.w, "104 Warning\nMessage: %s\n\n", fieldValue(msg))
 }
 
 // Warningf writes a '104 Warning' message and formats the arguments into it.
@@ -351,94 +359,95 @@ func (mw *MessageWriter) Warningf(fmtspec string, args ...interface{}) {
 }
 
 // StartURI writes a '200 URI Start' message.
-func (mw *MessageWriter) StartURI(uri, resumePoint string, size int64, usedMirror bool) {
-	fmt.Fprintf(mw.w, "200 URI Start\nURI: %s\n", uri)
+func (mw *MessageWriter) StartURI(uri, resumePoint string, size int64, usedMirror string) {
+	fmt.Fprintf(mw.w, "200 URI Start\nURI: %s\n", fieldValue(uri))
 	if resumePoint != "" {
-		fmt.Fprintf(mw.w, "Resume-Point: %s\n", resumePoint)
+		fmt.Fprintf(mw.w, "Resume-Point: %s\n", fieldValue(resumePoint))
 	}
 	if size > 0 {
 		fmt.Fprintf(mw.w, "Size: %d\n", size)
 	}
-	if usedMirror {
-		mw.w.Write([]byte("UsedMirror: true\n"))
+	if usedMirror != "" {
+		fmt.Fprintf(mw.w, "UsedMirror: %s\n", fieldValue(usedMirror))
 	}
 	mw.w.Write([]byte("\n"))
 }
 
 // FinishURI writes a '201 URI Done' message.
 func (mw *MessageWriter) FinishURI(uri, filename, resumePoint, altIMSHit string,
-	imsHit, usedMirror bool, extra ...Field) {
+	imsHit bool, usedMirror string, extra ...Field) {
 
-	fmt.Fprintf(mw.w, "201 URI Done\nURI: %s\nFilename: %s\n", uri, filename)
+	fmt.Fprintf(mw.w, "201 URI Done\nURI: %s\nFilename: %s\n", fieldValue(uri), fieldValue(filename))
 	if resumePoint != "" {
-		fmt.Fprintf(mw.w, "Resume-Point: %s\n", resumePoint)
+		fmt.Fprintf(mw.w, "Resume-Point: %s\n", fieldValue(resumePoint))
 	}
 	if imsHit {
 		mw.w.Write([]byte("IMS-Hit: true\n"))
 	}
 	if altIMSHit != "" {
-		fmt.Fprintf(mw.w, "Alt-IMS-Hit: %s\n", altIMSHit)
+		fmt.Fprintf(mw.w, "Alt-IMS-Hit: %s\n", fieldValue(altIMSHit))
 	}
-	if usedMirror {
-		mw.w.Write([]byte("UsedMirror: true\n"))
+	if usedMirror != "" {
+		fmt.Fprintf(mw.w, "UsedMirror: %s\n", fieldValue(usedMirror))
 	}
 
 	// TODO: Make this better...
 	for _, s := range extra {
-		fmt.Fprintf(mw.w, "%s: %s\n", s.Key, s.Value)
+		fmt.Fprintf(mw.w, "%s: %s\n", fieldValue(s.Key), fieldValue(s.Value))
 	}
 
 	mw.w.Write([]byte("\n"))
 }
 
 // AuxRequest writes a '351 Aux Request' message.
-func (mw *MessageWriter) AuxRequest(uri, auxURI, descShort, descLong string, maximumSize uint64, usedMirror bool) {
-	fmt.Fprintf(mw.w, "351 Aux Request\nURI: %s\n", uri)
+func (mw *MessageWriter) AuxRequest(uri, auxURI, descShort, descLong string, maximumSize uint64, usedMirror string) {
+	fmt.Fprintf(mw.w, "351 Aux Request\nURI: %s\n", fieldValue(uri))
 	if auxURI != "" {
-		fmt.Fprintf(mw.w, "Aux-URI: %s\n", auxURI)
+		fmt.Fprintf(mw.w, "Aux-URI: %s\n", fieldValue(auxURI))
 	}
 	if maximumSize > 0 {
 		fmt.Fprintf(mw.w, "MaximumSize: %d\n", maximumSize)
 	}
 	if descShort != "" {
-		fmt.Fprintf(mw.w, "Aux-ShortDesc: %s\n", descShort)
+		fmt.Fprintf(mw.w, "Aux-ShortDesc: %s\n", fieldValue(descShort))
 	}
 	if descLong != "" {
-		fmt.Fprintf(mw.w, "Aux-Description: %s\n", descLong)
+		fmt.Fprintf(mw.w, "Aux-Description: %s\n", fieldValue(descLong))
 	}
-	if usedMirror {
-		mw.w.Write([]byte("UsedMirror: true\n"))
+	if usedMirror != "" {
+		fmt.Fprintf(mw.w, "UsedMirror: %s\n", fieldValue(usedMirror))
 	}
 	mw.w.Write([]byte("\n"))
 }
 
 // FailedURI writes a '400 URI Failure' message.
 //
-// The message parameter should be "" unless the intent is to send a malformed
-// URI Failure message
-// failReason is only used if transientError is false
-func (mw *MessageWriter) FailedURI(uri, message, failReason string, transientError, usedMirror bool) {
+// If uri is "", only the message is written.
+// failReason is a reason code such as "HashSumMismatch", and is written only if it is not ""
+// and transientError is false.
+func (mw *MessageWriter) FailedURI(uri, message, failReason string, transientError bool, usedMirror string) {
 	mw.w.Write([]byte("400 URI Failure\n"))
 	if uri == "" {
-		fmt.Fprintf(mw.w, "Message: %s\n\n", message)
+		fmt.Fprintf(mw.w, "Message: %s\n\n", fieldValue(message))
 		return
 	}
-	fmt.Fprintf(mw.w, "URI: %s\n", uri)
+	fmt.Fprintf(mw.w, "URI: %s\n", fieldValue(uri))
+	fmt.Fprintf(mw.w, "Message: %s\n", fieldValue(message))
 
 	if transientError {
 		mw.w.Write([]byte("Transient-Failure: true\n"))
-	} else {
-		fmt.Fprintf(mw.w, "FailReason: %s\n", failReason)
+	} else if failReason != "" {
+		fmt.Fprintf(mw.w, "FailReason: %s\n", fieldValue(failReason))
 	}
-	if usedMirror {
-		mw.w.Write([]byte("UsedMirror: true\n"))
+	if usedMirror != "" {
+		fmt.Fprintf(mw.w, "UsedMirror: %s\n", fieldValue(usedMirror))
 	}
 	mw.w.Write([]byte("\n"))
 }
 
 // GeneralFailure writes a '401 General Failure' message.
 func (mw *MessageWriter) GeneralFailure(msg string) {
-	fmt.Fprintf(mw.w, "401 General Failure\nMessage: %s\n\n", msg)
+	fmt.Fprintf(mw.w, "401 General Failure\nMessage: %s\n\n", fieldValue(msg))
 }
 
 // GeneralFailuref writes a '401 General Failure' message and formats the
@@ -449,5 +458,17 @@ func (mw *MessageWriter) GeneralFailuref(fmtspec string, args ...interface{}) {
 
 // MediaChange writes a '403 Media Change' message.
 func (mw *MessageWriter) MediaChange(media, drive string) {
-	fmt.Fprintf(mw.w, "403 Media Change\nMedia: %s\nDrive: %s\n\n", media, drive)
+	fmt.Fprintf(mw.w, "403 Media Change\nMedia: %s\nDrive: %s\n\n", fieldValue(media), fieldValue(drive))
+}
+
+// fieldValue folds a multi-line value, such as the errors joined with errors.Join,
+// into a single line, as a newline terminates a field and an empty line terminates a message.
+func fieldValue(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+	lines := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	return strings.Join(lines, "; ")
 }
@@ -0,0 +1,33 @@
+package apt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFailedURIMultiLineMessage(t *testing.T) {
+	var b bytes.Buffer
+	mw := NewMessageWriter(&b)
+	err := errors.Join(errors.New("invalid signature 1"), errors.New("invalid signature 2\n"))
+	mw.FailedURI("oci://example.com/foo:latest/InRelease", err.Error(), "SignatureViolation", false, "")
+	expected := "400 URI Failure\n" +
+		"URI: oci://example.com/foo:latest/InRelease\n" +
+		"Message: invalid signature 1; invalid signature 2\n" +
+		"FailReason: SignatureViolation\n\n"
+	if got := b.String(); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestFailedURIWithoutFailReason(t *testing.T) {
+	var b bytes.Buffer
+	mw := NewMessageWriter(&b)
+	mw.FailedURI("oci://example.com/foo:latest/InRelease", "not found", "", false, "")
+	expected := "400 URI Failure\n" +
+		"URI: oci://example.com/foo:latest/InRelease\n" +
+		"Message: not found\n\n"
+	if got := b.String(); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
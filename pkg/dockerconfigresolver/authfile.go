@@ -0,0 +1,107 @@
+package dockerconfigresolver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/credentials"
+	dockercliconfigtypes "github.com/docker/cli/cli/config/types"
+	"github.com/sirupsen/logrus"
+)
+
+// EnvRegistryAuthFile overrides the primary containers-auth.json(5) file.
+const EnvRegistryAuthFile = "REGISTRY_AUTH_FILE"
+
+// containersAuthFiles returns the containers-auth.json(5) files in the lookup order.
+// $DOCKER_CONFIG/config.json is not included.
+func containersAuthFiles() []string {
+	var files []string
+	if f := os.Getenv(EnvRegistryAuthFile); f != "" {
+		files = append(files, f)
+	} else if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		files = append(files, filepath.Join(runtimeDir, "containers", "auth.json"))
+	} else {
+		files = append(files, fmt.Sprintf("/run/containers/%d/auth.json", os.Getuid()))
+	}
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		files = append(files, filepath.Join(configHome, "containers", "auth.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".config", "containers", "auth.json"))
+	}
+	files = append(files, "/etc/containers/auth.json")
+	return files
+}
+
+// getContainersAuthConfig returns the auth config for refHostname and repository
+// in the containers-auth.json(5) files.
+//
+// repository is like "foo/bar", and can be empty.
+// The returned auth config can be nil.
+func getContainersAuthConfig(refHostname, repository string) (*dockercliconfigtypes.AuthConfig, error) {
+	for _, f := range containersAuthFiles() {
+		ac, err := getAuthConfigFromContainersAuthFile(f, refHostname, repository)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auth config from %q: %w", f, err)
+		}
+		if ac != nil {
+			logrus.Debugf("using auth config %q from %q", ac.ServerAddress, f)
+			return ac, nil
+		}
+	}
+	return nil, nil
+}
+
+func getAuthConfigFromContainersAuthFile(fileName, refHostname, repository string) (*dockercliconfigtypes.AuthConfig, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	// The format of auth.json is compatible with ~/.docker/config.json
+	cf := configfile.New(fileName)
+	if err := cf.LoadFromReader(f); err != nil {
+		return nil, err
+	}
+
+	// Credential helpers are configured per registry
+	if helper := cf.CredentialHelpers[refHostname]; helper != "" {
+		ac, err := credentials.NewNativeStore(cf, helper).Get(refHostname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials from helper %q: %w", helper, err)
+		}
+		if !isAuthConfigEmpty(ac) {
+			return &ac, nil
+		}
+	}
+
+	// The most specific key wins: "example.com/foo/bar", "example.com/foo", "example.com"
+	key := refHostname
+	if repository != "" {
+		key += "/" + repository
+	}
+	for {
+		if ac, ok := cf.AuthConfigs[key]; ok && !isAuthConfigEmpty(ac) {
+			return &ac, nil
+		}
+		i := strings.LastIndex(key, "/")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+
+	// Legacy keys like "https://example.com/v1/"
+	for k, ac := range cf.AuthConfigs {
+		if strings.Contains(k, "://") && credentials.ConvertToHostname(k) == refHostname && !isAuthConfigEmpty(ac) {
+			return &ac, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package dockerconfigresolver
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetAuthConfigFromContainersAuthFile(t *testing.T) {
+	enc := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	authJSON := `{
+  "auths": {
+    "example.com": {"auth": "` + enc("host:pass") + `"},
+    "example.com/foo": {"auth": "` + enc("foo:pass") + `"},
+    "example.com/foo/bar": {"auth": "` + enc("foobar:pass") + `"},
+    "https://legacy.example.com/v1/": {"auth": "` + enc("legacy:pass") + `"}
+  }
+}`
+	fileName := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(fileName, []byte(authJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		host         string
+		repository   string
+		xUsername    string
+		xUnavailable bool
+	}{
+		{host: "example.com", repository: "foo/bar", xUsername: "foobar"},
+		{host: "example.com", repository: "foo/baz", xUsername: "foo"},
+		{host: "example.com", repository: "foobar", xUsername: "host"},
+		{host: "example.com", xUsername: "host"},
+		{host: "legacy.example.com", repository: "foo", xUsername: "legacy"},
+		{host: "other.example.com", repository: "foo/bar", xUnavailable: true},
+	} {
+		ac, err := getAuthConfigFromContainersAuthFile(fileName, tc.host, tc.repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.xUnavailable {
+			if ac != nil {
+				t.Fatalf("%s/%s: expected no auth config, got %+v", tc.host, tc.repository, ac)
+			}
+			continue
+		}
+		if ac == nil {
+			t.Fatalf("%s/%s: expected auth config", tc.host, tc.repository)
+		}
+		if ac.Username != tc.xUsername {
+			t.Fatalf("%s/%s: expected username %q, got %q", tc.host, tc.repository, tc.xUsername, ac.Username)
+		}
+	}
+}
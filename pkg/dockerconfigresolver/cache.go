@@ -0,0 +1,179 @@
+package dockerconfigresolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/containerd/v2/core/remotes/docker/config"
+	dockercliconfigtypes "github.com/docker/cli/cli/config/types"
+)
+
+// http2SendPingTimeout is the idle time after which a ping frame is sent on an HTTP/2 connection,
+// to keep the connection alive and to detect a dead connection.
+const http2SendPingTimeout = 15 * time.Second
+
+// Cache shares the resolvers, the authorizers, and the HTTP clients across the refs,
+// so that the refs on the same registry host reuse the connections and the bearer tokens.
+//
+// The HTTP clients are shared per registry host and options.
+// The authorizers are shared per registry host and credential scope,
+// i.e., the repositories with the same credentials share an authorizer,
+// and a repository with per-repository credentials in containers-auth.json(5) has its own authorizer.
+//
+// The proxy function (WithProxy) is not a part of the key;
+// the proxy function of the first resolver is used for the same options.
+//
+// Cache is safe for concurrent use.
+type Cache struct {
+	mu        sync.Mutex
+	clients   map[clientKey]*cachedHosts
+	resolvers map[resolverKey]remotes.Resolver
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{
+		clients:   make(map[clientKey]*cachedHosts),
+		resolvers: make(map[resolverKey]remotes.Resolver),
+	}
+}
+
+// clientKey is the key of the options that affect the HTTP clients.
+type clientKey struct {
+	plainHTTP       bool
+	skipVerifyCerts bool
+	hostsDir        string
+}
+
+type resolverKey struct {
+	clientKey
+	refHostname string
+	// scope is the digest of the credentials
+	scope string
+}
+
+// authorizerKey is the key of an authorizer of a registry host (including mirrors).
+type authorizerKey struct {
+	host string
+	// scope is the digest of the credentials, or empty for the mirrors in hosts.toml
+	scope string
+}
+
+// cachedHosts caches the registry hosts of the same options.
+type cachedHosts struct {
+	configure   docker.RegistryHosts
+	hosts       map[string][]docker.RegistryHost
+	authorizers map[authorizerKey]docker.Authorizer
+}
+
+// Resolver returns a resolver like New, sharing the HTTP clients and the authorizers
+// with the other resolvers of the cache.
+func (c *Cache) Resolver(refHostname string, optFuncs ...Opt) (remotes.Resolver, error) {
+	o := newOpts(optFuncs)
+	ac, err := getAuthConfig(refHostname, o.repository)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := credentialScope(ac)
+	if err != nil {
+		return nil, err
+	}
+	refHost, err := docker.DefaultHost(refHostname)
+	if err != nil {
+		return nil, err
+	}
+	ck := clientKey{plainHTTP: o.plainHTTP, skipVerifyCerts: o.skipVerifyCerts, hostsDir: o.hostsDir}
+	rk := resolverKey{clientKey: ck, refHostname: refHostname, scope: scope}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resolver, ok := c.resolvers[rk]; ok {
+		return resolver, nil
+	}
+	ch, ok := c.clients[ck]
+	if !ok {
+		o.http2 = true
+		hostOpts := o.hostOptions()
+		ch = &cachedHosts{
+			configure:   config.ConfigureHosts(context.TODO(), hostOpts),
+			hosts:       make(map[string][]docker.RegistryHost),
+			authorizers: make(map[authorizerKey]docker.Authorizer),
+		}
+		c.clients[ck] = ch
+	}
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var err error
+		rhosts, ok := ch.hosts[host]
+		if !ok {
+			rhosts, err = ch.configure(host)
+			if err != nil {
+				return nil, err
+			}
+			ch.hosts[host] = rhosts
+		}
+		// The authorizers differ across the resolvers
+		rhosts = slices.Clone(rhosts)
+		for i := range rhosts {
+			ak := authorizerKey{host: rhosts[i].Host}
+			if rhosts[i].Host == refHost {
+				ak.scope = scope
+			}
+			authz, ok := ch.authorizers[ak]
+			if !ok {
+				if rhosts[i].Host == refHost {
+					authz, err = newAuthorizer(refHostname, ac, rhosts[i].Client)
+				} else {
+					// A mirror in hosts.toml
+					authz, err = NewAuthorizer(rhosts[i].Host, "", rhosts[i].Client)
+				}
+				if err != nil {
+					return nil, err
+				}
+				ch.authorizers[ak] = authz
+			}
+			rhosts[i].Authorizer = authz
+		}
+		return rhosts, nil
+	}
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: hosts,
+	})
+	c.resolvers[rk] = resolver
+	return resolver, nil
+}
+
+// credentialScope returns the digest of the credentials in ac.
+// The server address is not a part of the scope, as the same credentials may be stored
+// for multiple repositories.
+func credentialScope(ac *dockercliconfigtypes.AuthConfig) (string, error) {
+	var creds any
+	if ac != nil {
+		creds = []string{ac.Username, ac.Password, ac.Auth, ac.IdentityToken, ac.RegistryToken}
+	}
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// enableHTTP2 enables HTTP/2 for tr, which is not enabled by default
+// for the transports with a custom dialer or TLS config.
+func enableHTTP2(tr *http.Transport) {
+	tr.ForceAttemptHTTP2 = true
+	if tr.HTTP2 == nil {
+		tr.HTTP2 = &http.HTTP2Config{}
+	}
+	tr.HTTP2.SendPingTimeout = http2SendPingTimeout
+}
@@ -0,0 +1,105 @@
+package dockerconfigresolver
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// isolateAuthConfig isolates the test from the auth config files of the host.
+// "/etc/containers/auth.json" is still read, if any.
+func isolateAuthConfig(t *testing.T, authJSON string) {
+	dir := t.TempDir()
+	authFile := filepath.Join(dir, "auth.json")
+	if err := os.WriteFile(authFile, []byte(authJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvRegistryAuthFile, authFile)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("DOCKER_CONFIG", dir)
+}
+
+func TestCacheResolver(t *testing.T) {
+	enc := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	isolateAuthConfig(t, `{
+  "auths": {
+    "example.com": {"auth": "`+enc("host:pass")+`"},
+    "example.com/foo": {"auth": "`+enc("host:pass")+`"},
+    "example.com/secret": {"auth": "`+enc("secret:pass")+`"}
+  }
+}`)
+	c := NewCache()
+	resolver := func(repository string, optFuncs ...Opt) remotes.Resolver {
+		r, err := c.Resolver("example.com", append([]Opt{WithRepository(repository)}, optFuncs...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	bar := resolver("bar")
+	// The same credentials, even from another entry
+	if resolver("foo") != bar {
+		t.Fatal("expected the resolver to be shared for the same credentials")
+	}
+	if resolver("secret") == bar {
+		t.Fatal("expected another resolver for the per-repository credentials")
+	}
+	if resolver("bar", WithPlainHTTP(true)) == bar {
+		t.Fatal("expected another resolver for another option")
+	}
+}
+
+func TestCacheResolverConnections(t *testing.T) {
+	isolateAuthConfig(t, `{"auths": {}}`)
+	manifest := []byte("{}")
+	dgst := digest.FromBytes(manifest)
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(manifest)
+		}
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	c := NewCache()
+	for _, repository := range []string{"foo", "bar", "baz"} {
+		r, err := c.Resolver(host, WithPlainHTTP(true), WithRepository(repository))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, desc, err := r.Resolve(ctx, host+"/"+repository+":latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if desc.Digest != dgst {
+			t.Fatalf("expected %s, got %s", dgst, desc.Digest)
+		}
+	}
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("expected the connection to be reused, got %d connections", got)
+	}
+}
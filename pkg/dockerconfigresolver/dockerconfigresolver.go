@@ -24,12 +24,16 @@
 package dockerconfigresolver
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 
 	"github.com/containerd/containerd/v2/core/remotes"
 	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/containerd/v2/core/remotes/docker/config"
 	dockercliconfig "github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/config/credentials"
 	dockercliconfigtypes "github.com/docker/cli/cli/config/types"
@@ -39,6 +43,11 @@ import (
 type opts struct {
 	plainHTTP       bool
 	skipVerifyCerts bool
+	repository      string
+	hostsDir        string
+	proxy           func(*http.Request) (*url.URL, error)
+	// http2 is set by Cache
+	http2 bool
 }
 
 // Opt for New
@@ -58,60 +67,179 @@ func WithSkipVerifyCerts(b bool) Opt {
 	}
 }
 
-// New instantiates a resolver using $DOCKER_CONFIG/config.json .
-//
-// $DOCKER_CONFIG defaults to "~/.docker".
-//
-// refHostname is like "docker.io".
-func New(refHostname string, optFuncs ...Opt) (remotes.Resolver, error) {
+// WithRepository specifies the repository path like "foo/bar",
+// for looking up per-repository credentials in containers-auth.json(5).
+func WithRepository(s string) Opt {
+	return func(o *opts) {
+		o.repository = s
+	}
+}
+
+// WithHostsDir specifies the directory that contains "<host>/hosts.toml" files,
+// such as "/etc/containerd/certs.d".
+// See https://github.com/containerd/containerd/blob/main/docs/hosts.md
+func WithHostsDir(s string) Opt {
+	return func(o *opts) {
+		o.hostsDir = s
+	}
+}
+
+// WithProxy specifies the proxy function for http.Transport .
+// The proxy environment variables are used by default.
+func WithProxy(f func(*http.Request) (*url.URL, error)) Opt {
+	return func(o *opts) {
+		o.proxy = f
+	}
+}
+
+func newOpts(optFuncs []Opt) *opts {
 	var o opts
 	for _, of := range optFuncs {
 		of(&o)
 	}
-	var authzOpts []docker.AuthorizerOpt
-	if authCreds, err := NewAuthCreds(refHostname); err != nil {
-		return nil, err
-	} else {
-		authzOpts = append(authzOpts, docker.WithAuthCreds(authCreds))
+	return &o
+}
+
+// hostOptions returns the options for config.ConfigureHosts.
+func (o *opts) hostOptions() config.HostOptions {
+	hostOpts := config.HostOptions{}
+	if o.hostsDir != "" {
+		hostOpts.HostDir = config.HostDirFromRoot(o.hostsDir)
 	}
-	authz := docker.NewDockerAuthorizer(authzOpts...)
-	plainHTTPFunc := docker.MatchLocalhost
 	if o.plainHTTP {
-		plainHTTPFunc = docker.MatchAllHosts
-	}
-	regOpts := []docker.RegistryOpt{
-		docker.WithAuthorizer(authz),
-		docker.WithPlainHTTP(plainHTTPFunc),
+		hostOpts.DefaultScheme = "http"
 	}
 	if o.skipVerifyCerts {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+		hostOpts.DefaultTLS = &tls.Config{
+			InsecureSkipVerify: true,
 		}
-		client := &http.Client{
-			Transport: tr,
+	}
+	hostOpts.UpdateClient = func(client *http.Client) error {
+		if _, ok := client.Transport.(*rangeTransport); ok {
+			// Already updated
+			return nil
+		}
+		tr, ok := client.Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("expected *http.Transport, got %T", client.Transport)
+		}
+		if o.proxy != nil {
+			tr.Proxy = o.proxy
+		}
+		if o.http2 {
+			enableHTTP2(tr)
+		}
+		client.Transport = &rangeTransport{tr}
+		return nil
+	}
+	return hostOpts
+}
+
+// New instantiates a resolver using containers-auth.json(5) and $DOCKER_CONFIG/config.json .
+//
+// $DOCKER_CONFIG defaults to "~/.docker".
+//
+// refHostname is like "docker.io".
+func New(refHostname string, optFuncs ...Opt) (remotes.Resolver, error) {
+	o := newOpts(optFuncs)
+	refHost, err := docker.DefaultHost(refHostname)
+	if err != nil {
+		return nil, err
+	}
+	configureHosts := config.ConfigureHosts(context.TODO(), o.hostOptions())
+	// authorizers are indexed by the registry host (including mirrors), and guarded by mu,
+	// as the resolver may be used concurrently
+	var mu sync.Mutex
+	authorizers := make(map[string]docker.Authorizer)
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		rhosts, err := configureHosts(host)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range rhosts {
+			authz, ok := authorizers[rhosts[i].Host]
+			if !ok {
+				if rhosts[i].Host == refHost {
+					authz, err = NewAuthorizer(refHostname, o.repository, rhosts[i].Client)
+				} else {
+					// A mirror in hosts.toml
+					authz, err = NewAuthorizer(rhosts[i].Host, "", rhosts[i].Client)
+				}
+				if err != nil {
+					return nil, err
+				}
+				authorizers[rhosts[i].Host] = authz
+			}
+			rhosts[i].Authorizer = authz
 		}
-		regOpts = append(regOpts, docker.WithClient(client))
+		return rhosts, nil
 	}
 	resovlerOpts := docker.ResolverOptions{
-		Hosts: docker.ConfigureDefaultRegistries(regOpts...),
+		Hosts: hosts,
 	}
 	resolver := docker.NewResolver(resovlerOpts)
 	return resolver, nil
 }
 
+// NewAuthorizer returns docker.Authorizer that uses containers-auth.json(5) and $DOCKER_CONFIG/config.json .
+//
+// When the config has "registrytoken", the token is sent as a bearer token as is.
+// When the config has "identitytoken", the token is exchanged for a bearer token
+// using the OAuth2 "refresh_token" grant.
+// Otherwise the username and the password are used.
+//
+// repository is like "foo/bar", and can be empty.
+// client can be nil.
+func NewAuthorizer(refHostname, repository string, client *http.Client) (docker.Authorizer, error) {
+	ac, err := getAuthConfig(refHostname, repository)
+	if err != nil {
+		return nil, err
+	}
+	return newAuthorizer(refHostname, ac, client)
+}
+
+func newAuthorizer(refHostname string, ac *dockercliconfigtypes.AuthConfig, client *http.Client) (docker.Authorizer, error) {
+	if ac != nil && (ac.RegistryToken != "" || ac.IdentityToken != "") {
+		// DefaultHost converts "docker.io" to "registry-1.docker.io"
+		host, err := docker.DefaultHost(refHostname)
+		if err != nil {
+			return nil, err
+		}
+		return newTokenAuthorizer(client, host, ac.RegistryToken, ac.IdentityToken), nil
+	}
+	authCreds, err := newAuthCreds(refHostname, ac)
+	if err != nil {
+		return nil, err
+	}
+	authzOpts := []docker.AuthorizerOpt{docker.WithAuthCreds(authCreds)}
+	if client != nil {
+		authzOpts = append(authzOpts, docker.WithAuthClient(client))
+	}
+	return docker.NewDockerAuthorizer(authzOpts...), nil
+}
+
 // AuthCreds is for docker.WithAuthCreds
 type AuthCreds func(string) (string, string, error)
 
-// NewAuthCreds returns AuthCreds that uses $DOCKER_CONFIG/config.json .
+// NewAuthCreds returns AuthCreds that uses containers-auth.json(5) and $DOCKER_CONFIG/config.json .
 // AuthCreds can be nil.
+//
+// NewAuthCreds is not aware of "registrytoken", and it returns "identitytoken" as a password.
+// Use NewAuthorizer to handle these tokens properly.
 func NewAuthCreds(refHostname string) (AuthCreds, error) {
-	// Load does not raise an error on ENOENT
-	dockerConfigFile, err := dockercliconfig.Load("")
+	ac, err := getAuthConfig(refHostname, "")
 	if err != nil {
 		return nil, err
 	}
+	return newAuthCreds(refHostname, ac)
+}
+
+func newAuthCreds(refHostname string, ac *dockercliconfigtypes.AuthConfig) (AuthCreds, error) {
+	if ac == nil {
+		return nil, nil
+	}
 
 	// DefaultHost converts "docker.io" to "registry-1.docker.io",
 	// which is wanted  by credFunc .
@@ -120,7 +248,33 @@ func NewAuthCreds(refHostname string) (AuthCreds, error) {
 		return nil, err
 	}
 
-	var credFunc AuthCreds
+	credFunc := func(credFuncArg string) (string, string, error) {
+		// credFuncArg should be like "registry-1.docker.io"
+		if credFuncArg != credFuncExpectedHostname {
+			return "", "", fmt.Errorf("expected credFuncExpectedHostname=%q (refHostname=%q), got credFuncArg=%q",
+				credFuncExpectedHostname, refHostname, credFuncArg)
+		}
+		if ac.IdentityToken != "" {
+			return "", ac.IdentityToken, nil
+		}
+		return ac.Username, ac.Password, nil
+	}
+	return credFunc, nil
+}
+
+// getAuthConfig returns the auth config for refHostname and repository
+// in containers-auth.json(5) or $DOCKER_CONFIG/config.json .
+// The returned auth config can be nil.
+func getAuthConfig(refHostname, repository string) (*dockercliconfigtypes.AuthConfig, error) {
+	if ac, err := getContainersAuthConfig(refHostname, repository); err != nil || ac != nil {
+		return ac, err
+	}
+
+	// Load does not raise an error on ENOENT
+	dockerConfigFile, err := dockercliconfig.Load("")
+	if err != nil {
+		return nil, err
+	}
 
 	authConfigHostnames := []string{refHostname}
 	if refHostname == "docker.io" || refHostname == "registry-1.docker.io" {
@@ -153,30 +307,12 @@ func NewAuthCreds(refHostname string) (AuthCreds, error) {
 							ac.ServerAddress, authConfigHostname, acsaHostname)
 					}
 				}
-
-				if ac.RegistryToken != "" {
-					// Even containerd/CRI does not support RegistryToken as of v1.4.3,
-					// so, nobody is actually using RegistryToken?
-					logrus.Warnf("ac.RegistryToken (for %q) is not supported yet (FIXME)", authConfigHostname)
-				}
-
-				credFunc = func(credFuncArg string) (string, string, error) {
-					// credFuncArg should be like "registry-1.docker.io"
-					if credFuncArg != credFuncExpectedHostname {
-						return "", "", fmt.Errorf("expected credFuncExpectedHostname=%q (refHostname=%q), got credFuncArg=%q",
-							credFuncExpectedHostname, refHostname, credFuncArg)
-					}
-					if ac.IdentityToken != "" {
-						return "", ac.IdentityToken, nil
-					}
-					return ac.Username, ac.Password, nil
-				}
-				break
+				return &ac, nil
 			}
 		}
 	}
-	// credsFunc can be nil here
-	return credFunc, nil
+	// ac can be nil here
+	return nil, nil
 }
 
 func isAuthConfigEmpty(ac dockercliconfigtypes.AuthConfig) bool {
@@ -0,0 +1,89 @@
+package dockerconfigresolver
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewHostsDirMirror(t *testing.T) {
+	manifest := []byte("{}")
+	dgst := digest.FromBytes(manifest)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to the upstream: %s %s", r.Method, r.URL)
+		http.Error(w, "unexpected", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The mirror accepts only its own credentials
+		if user, pass, ok := r.BasicAuth(); !ok || user != "mirror" || pass != "mirror-pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mirror"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(manifest)
+		}
+	}))
+	defer mirror.Close()
+	upstreamHost := upstream.Listener.Addr().String()
+	mirrorHost := mirror.Listener.Addr().String()
+
+	enc := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	isolateAuthConfig(t, fmt.Sprintf(`{
+  "auths": {
+    %q: {"auth": %q},
+    %q: {"auth": %q}
+  }
+}`, upstreamHost, enc("upstream:upstream-pass"), mirrorHost, enc("mirror:mirror-pass")))
+	hostsDir := t.TempDir()
+	hostsToml := fmt.Sprintf(`server = "http://%s"
+
+[host."http://%s"]
+  capabilities = ["pull", "resolve"]
+`, upstreamHost, mirrorHost)
+	if err := os.MkdirAll(filepath.Join(hostsDir, upstreamHost), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(hostsDir, upstreamHost, "hosts.toml"), []byte(hostsToml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(upstreamHost, WithPlainHTTP(true), WithRepository("foo"), WithHostsDir(hostsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The resolver is used concurrently
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, desc, err := r.Resolve(ctx, upstreamHost+"/foo:latest")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if desc.Digest != dgst {
+				t.Errorf("expected %s, got %s", dgst, desc.Digest)
+			}
+		}()
+	}
+	wg.Wait()
+}
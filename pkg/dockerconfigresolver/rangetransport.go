@@ -0,0 +1,40 @@
+package dockerconfigresolver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+)
+
+// rangeTransport bounds the open-ended ranged requests ("Range: bytes=off-")
+// with the end in the context. See ociutil.WithRangeEnd.
+type rangeTransport struct {
+	*http.Transport
+}
+
+func (t *rangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	end, ok := ociutil.RangeEnd(req.Context())
+	if !ok {
+		return t.Transport.RoundTrip(req)
+	}
+	// Only the open-ended ranges are bounded, so that the requests without ranges
+	// (e.g., the manifests resolved with the same context) are not truncated.
+	s, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if !ok {
+		return t.Transport.RoundTrip(req)
+	}
+	s, ok = strings.CutSuffix(s, "-")
+	if !ok {
+		return t.Transport.RoundTrip(req)
+	}
+	off, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || off >= end {
+		return t.Transport.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	return t.Transport.RoundTrip(req)
+}
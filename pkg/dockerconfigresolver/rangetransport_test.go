@@ -0,0 +1,61 @@
+package dockerconfigresolver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRangeTransport(t *testing.T) {
+	isolateAuthConfig(t, `{"auths": {}}`)
+	blob := bytes.Repeat([]byte("0123456789"), 1000)
+	desc := ocispec.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/foo/blobs/"+desc.Digest.String() {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	resolver, err := New(host, WithPlainHTTP(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher, err := resolver.Fetcher(ctx, host+"/foo:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ociutil.ReadRange(ctx, fetcher, desc, 100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, blob[100:110]) {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=100-109" {
+		t.Fatalf("expected a bounded range, got %q", ranges)
+	}
+}
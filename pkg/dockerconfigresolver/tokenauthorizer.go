@@ -0,0 +1,134 @@
+package dockerconfigresolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/containerd/v2/core/remotes/docker/auth"
+	"github.com/containerd/errdefs"
+)
+
+// oauthClientID is sent as the "client_id" parameter of the OAuth2 token requests.
+const oauthClientID = "apt-transport-oci"
+
+// defaultTokenExpiration is used when the token endpoint does not specify "expires_in".
+// https://distribution.github.io/distribution/spec/auth/token/#token-response-fields
+const defaultTokenExpiration = 60 * time.Second
+
+// tokenAuthorizer implements docker.Authorizer for the token fields of the Docker config:
+//
+//   - "registrytoken" is sent to the registry as is, as `Authorization: Bearer <registrytoken>`.
+//   - "identitytoken" is an OAuth2 refresh token. It is exchanged for a bearer token at the
+//     token endpoint using the "refresh_token" grant. Unlike docker.NewDockerAuthorizer,
+//     tokenAuthorizer never falls back to sending the identity token as a password.
+type tokenAuthorizer struct {
+	client        *http.Client
+	host          string // like "registry-1.docker.io"
+	registryToken string
+
+	mu            sync.Mutex
+	identityToken string
+	common        *auth.TokenOptions
+	tokens        map[string]bearerToken // indexed by the space-separated scopes
+}
+
+type bearerToken struct {
+	token      string
+	expiration time.Time
+}
+
+func newTokenAuthorizer(client *http.Client, host, registryToken, identityToken string) *tokenAuthorizer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &tokenAuthorizer{
+		client:        client,
+		host:          host,
+		registryToken: registryToken,
+		identityToken: identityToken,
+		tokens:        make(map[string]bearerToken),
+	}
+}
+
+// Authorize implements docker.Authorizer.
+func (a *tokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if req.URL.Host != a.host {
+		// Do not leak the tokens to other hosts
+		return nil
+	}
+	if a.registryToken != "" {
+		req.Header.Set("Authorization", "Bearer "+a.registryToken)
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.common == nil {
+		// Wait for the challenge (AddResponses)
+		return nil
+	}
+	to := *a.common
+	to.Secret = a.identityToken
+	to.Scopes = docker.GetTokenScopes(ctx, to.Scopes)
+	scoped := strings.Join(to.Scopes, " ")
+	if t, ok := a.tokens[scoped]; ok && t.expiration.After(time.Now()) {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+		return nil
+	}
+	// to.Username is empty, so FetchTokenWithOAuth uses the "refresh_token" grant
+	resp, err := auth.FetchTokenWithOAuth(ctx, a.client, nil, oauthClientID, to)
+	if err != nil {
+		return fmt.Errorf("failed to exchange the identity token for a bearer token (realm=%q): %w", to.Realm, err)
+	}
+	expiration := defaultTokenExpiration
+	if resp.ExpiresInSeconds > 0 {
+		expiration = time.Duration(resp.ExpiresInSeconds) * time.Second
+	}
+	a.tokens[scoped] = bearerToken{
+		token:      resp.AccessToken,
+		expiration: time.Now().Add(expiration),
+	}
+	if resp.RefreshToken != "" {
+		// The token endpoint may rotate the refresh token
+		a.identityToken = resp.RefreshToken
+	}
+	req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+	return nil
+}
+
+// AddResponses implements docker.Authorizer.
+func (a *tokenAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	if host != a.host {
+		return fmt.Errorf("no token is configured for host %q: %w", host, errdefs.ErrNotImplemented)
+	}
+	if a.registryToken != "" {
+		return fmt.Errorf("registry token was rejected by %q: %w", host, docker.ErrInvalidAuthorization)
+	}
+	rejected := last.Request.Header.Get("Authorization") != ""
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range auth.ParseAuthHeader(last.Header) {
+		if c.Scheme != auth.BearerAuth {
+			continue
+		}
+		if rejected {
+			a.tokens = make(map[string]bearerToken)
+			return fmt.Errorf("bearer token obtained with the identity token was rejected by %q: %w", host, docker.ErrInvalidAuthorization)
+		}
+		to, err := auth.GenerateTokenOptions(ctx, host, "", a.identityToken, c)
+		if err != nil {
+			return err
+		}
+		a.common = &to
+		return nil
+	}
+	return fmt.Errorf("identity token requires the bearer auth scheme (host %q): %w", host, errdefs.ErrNotImplemented)
+}
@@ -0,0 +1,95 @@
+package dockerconfigresolver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTokenAuthorizerIdentityToken(t *testing.T) {
+	var grants []url.Values
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		grants = append(grants, r.PostForm)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-token",
+			"refresh_token": "rotated-identity-token",
+			"expires_in":    300,
+		})
+	}))
+	defer tokenSrv.Close()
+
+	const host = "registry.example.com"
+	a := newTokenAuthorizer(tokenSrv.Client(), host, "", "identity-token")
+	ctx := context.Background()
+
+	req := httptest.NewRequest(http.MethodGet, "https://"+host+"/v2/foo/manifests/latest", nil)
+	if err := a.Authorize(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Fatalf("expected no Authorization header before the challenge, got %q", got)
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header: http.Header{
+			"Www-Authenticate": []string{`Bearer realm="` + tokenSrv.URL + `",service="registry.example.com",scope="repository:foo:pull"`},
+		},
+		Request: req,
+	}
+	if err := a.AddResponses(ctx, []*http.Response{resp}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodGet, "https://"+host+"/v2/foo/manifests/latest", nil)
+		if err := a.Authorize(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer access-token" {
+			t.Fatalf("expected %q, got %q", "Bearer access-token", got)
+		}
+	}
+	if len(grants) != 1 {
+		t.Fatalf("expected the token to be cached, got %d token requests", len(grants))
+	}
+	if got := grants[0].Get("grant_type"); got != "refresh_token" {
+		t.Fatalf("expected grant_type %q, got %q", "refresh_token", got)
+	}
+	if got := grants[0].Get("refresh_token"); got != "identity-token" {
+		t.Fatalf("expected refresh_token %q, got %q", "identity-token", got)
+	}
+	if a.identityToken != "rotated-identity-token" {
+		t.Fatalf("expected the identity token to be rotated, got %q", a.identityToken)
+	}
+}
+
+func TestTokenAuthorizerRegistryToken(t *testing.T) {
+	a := newTokenAuthorizer(nil, "registry.example.com", "registry-token", "")
+	ctx := context.Background()
+
+	req := httptest.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+	if err := a.Authorize(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer registry-token" {
+		t.Fatalf("expected %q, got %q", "Bearer registry-token", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "https://blobs.example.net/foo", nil)
+	if err := a.Authorize(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Fatalf("expected the token not to be sent to another host, got %q", got)
+	}
+}
@@ -0,0 +1,242 @@
+// Package ledger implements the trust-on-first-use ledger of the root digests resolved for each reference,
+// for protecting the clients from rollbacks and tag moves to older artifacts.
+package ledger
+
+import (
+	_ "crypto/sha256" // for digest.Validate
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultDir is the default directory of the ledger.
+// The directory has to be writable by the user that runs the apt methods ("_apt").
+const DefaultDir = "/var/lib/apt-transport-oci/ledger"
+
+const (
+	ledgerFile = "ledger.json"
+	lockFile   = "ledger.lock"
+
+	// maxRecords is the maximum number of the records kept for each reference
+	maxRecords = 100
+)
+
+// ErrRollback is returned when a reference moves to an older artifact.
+var ErrRollback = errors.New("rollback detected")
+
+// Record is a root digest resolved for a reference.
+type Record struct {
+	Digest digest.Digest `json:"digest"`
+	// Created is the "org.opencontainers.image.created" annotation, if any
+	Created   time.Time `json:"created,omitzero"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Approved is true when the record was approved with Approve
+	Approved bool `json:"approved,omitempty"`
+}
+
+// Entry is the ledger entry of a reference.
+type Entry struct {
+	Ref string `json:"ref"`
+	// Current is the digest currently accepted for the reference
+	Current digest.Digest `json:"current"`
+	// Newest is the newest "created" timestamp accepted for the reference.
+	// Approve may lower it.
+	Newest  time.Time `json:"newest,omitzero"`
+	Records []Record  `json:"records"`
+}
+
+func (e *Entry) record(dgst digest.Digest) *Record {
+	for i := range e.Records {
+		if e.Records[i].Digest == dgst {
+			return &e.Records[i]
+		}
+	}
+	return nil
+}
+
+type document struct {
+	Entries map[string]*Entry `json:"entries"`
+}
+
+// Ledger is the ledger stored in a directory.
+type Ledger struct {
+	dir string
+}
+
+// New instantiates Ledger. The directory has to exist.
+func New(dir string) *Ledger {
+	return &Ledger{dir: dir}
+}
+
+// update calls f with the document, under the lock.
+// The document is written back when f returns true.
+func (l *Ledger) update(f func(doc *document) (bool, error)) error {
+	lock, err := os.OpenFile(filepath.Join(l.dir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the ledger: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	doc, err := l.read()
+	if err != nil {
+		return err
+	}
+	write, err := f(doc)
+	if err != nil || !write {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(l.dir, ledgerFile+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.dir, ledgerFile))
+}
+
+func (l *Ledger) read() (*document, error) {
+	doc := &document{Entries: make(map[string]*Entry)}
+	b, err := os.ReadFile(filepath.Join(l.dir, ledgerFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return doc, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse the ledger: %w", err)
+	}
+	if doc.Entries == nil {
+		doc.Entries = make(map[string]*Entry)
+	}
+	return doc, nil
+}
+
+// Check checks that ref may resolve to dgst, and records it.
+// created is the "org.opencontainers.image.created" annotation of dgst, or the zero time if unknown.
+//
+// An error wrapping ErrRollback is returned, without recording dgst, when:
+//   - created is older than the newest timestamp already accepted for ref,
+//   - created is unknown, while a timestamp was already accepted for ref, or
+//   - created is unknown, and dgst was replaced by another digest before.
+//
+// The digests with unknown created timestamps are accepted on first use
+// only for the refs that never had timestamps.
+// A rejected digest is accepted after Approve.
+func (l *Ledger) Check(ref string, dgst digest.Digest, created time.Time) error {
+	now := time.Now().UTC()
+	return l.update(func(doc *document) (bool, error) {
+		e, ok := doc.Entries[ref]
+		if !ok {
+			e = &Entry{Ref: ref}
+			doc.Entries[ref] = e
+		}
+		rec := e.record(dgst)
+		if dgst != e.Current && e.Current != "" {
+			switch {
+			case !created.IsZero() && created.Before(e.Newest):
+				return false, fmt.Errorf("%w: %q moved from %s to %s, which was created at %s, before %s (Hint: run `apt-transport-oci ledger approve %s %s` to approve)",
+					ErrRollback, ref, e.Current, dgst, created.Format(time.RFC3339), e.Newest.Format(time.RFC3339), ref, dgst)
+			case created.IsZero() && !e.Newest.IsZero():
+				return false, fmt.Errorf("%w: %q moved from %s to %s, which has no created timestamp, while %s was accepted (Hint: run `apt-transport-oci ledger approve %s %s` to approve)",
+					ErrRollback, ref, e.Current, dgst, e.Newest.Format(time.RFC3339), ref, dgst)
+			case created.IsZero() && rec != nil:
+				return false, fmt.Errorf("%w: %q moved back from %s to %s, which was seen at %s (Hint: run `apt-transport-oci ledger approve %s %s` to approve)",
+					ErrRollback, ref, e.Current, dgst, rec.LastSeen.Format(time.RFC3339), ref, dgst)
+			}
+		}
+		if rec == nil {
+			e.Records = append(e.Records, Record{Digest: dgst, Created: created, FirstSeen: now})
+			if len(e.Records) > maxRecords {
+				e.Records = e.Records[len(e.Records)-maxRecords:]
+			}
+			rec = &e.Records[len(e.Records)-1]
+		}
+		rec.LastSeen = now
+		e.Current = dgst
+		if created.After(e.Newest) {
+			e.Newest = created
+		}
+		return true, nil
+	})
+}
+
+// Approve approves ref to resolve to dgst, even if dgst is older than the digests already accepted.
+func (l *Ledger) Approve(ref string, dgst digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	return l.update(func(doc *document) (bool, error) {
+		e, ok := doc.Entries[ref]
+		if !ok {
+			e = &Entry{Ref: ref}
+			doc.Entries[ref] = e
+		}
+		rec := e.record(dgst)
+		if rec == nil {
+			e.Records = append(e.Records, Record{Digest: dgst, FirstSeen: now, LastSeen: now})
+			rec = &e.Records[len(e.Records)-1]
+		}
+		rec.Approved = true
+		e.Current = dgst
+		// The created timestamp of a digest that was never recorded is unknown,
+		// and the timestamps accepted before are still enforced for the other digests
+		if !rec.Created.IsZero() {
+			e.Newest = rec.Created
+		}
+		return true, nil
+	})
+}
+
+// Forget removes the entry of ref.
+func (l *Ledger) Forget(ref string) error {
+	return l.update(func(doc *document) (bool, error) {
+		if _, ok := doc.Entries[ref]; !ok {
+			return false, fmt.Errorf("%q is not in the ledger", ref)
+		}
+		delete(doc.Entries, ref)
+		return true, nil
+	})
+}
+
+// List returns the entries sorted by the references.
+func (l *Ledger) List() ([]Entry, error) {
+	doc, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(doc.Entries))
+	for _, e := range doc.Entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Ref < entries[j].Ref
+	})
+	return entries, nil
+}
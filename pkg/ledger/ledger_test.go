@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestLedger(t *testing.T) {
+	l := New(t.TempDir())
+	const ref = "ghcr.io/foo/bar:stable"
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d1, d2, d3 := digest.FromString("1"), digest.FromString("2"), digest.FromString("3")
+
+	// Trust on first use
+	if err := l.Check(ref, d2, t0.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ref, d2, t0.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Older than d2
+	if err := l.Check(ref, d1, t0); !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+	// Newer than d2
+	if err := l.Check(ref, d3, t0.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Back to d2, which has no "created" annotation in this call
+	if err := l.Check(ref, d2, time.Time{}); !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+	// A new digest without "created", after the timestamps were accepted
+	d4 := digest.FromString("4")
+	if err := l.Check(ref, d4, time.Time{}); !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+
+	// Approve a digest that was never recorded
+	d5 := digest.FromString("5")
+	if err := l.Approve(ref, d5); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Current != d5 || !entries[0].Newest.Equal(t0.Add(2*time.Hour)) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if err := l.Check(ref, d5, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// The timestamps accepted before are still enforced
+	if err := l.Check(ref, d1, t0); !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+
+	// Approve the rollback to d1
+	if err := l.Approve(ref, d1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ref, d1, t0); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Current != d1 || len(entries[0].Records) != 4 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	if err := l.Forget(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ref, d1, t0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLedgerWithoutCreated(t *testing.T) {
+	l := New(t.TempDir())
+	const ref = "ghcr.io/foo/bar:stable"
+	d1, d2 := digest.FromString("1"), digest.FromString("2")
+
+	// The digests are accepted on first use when the ref never had timestamps
+	if err := l.Check(ref, d1, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ref, d2, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// Back to d1
+	if err := l.Check(ref, d1, time.Time{}); !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+	if err := l.Approve(ref, d1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(ref, d1, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package method
+
+import (
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	"github.com/containerd/containerd/v2/core/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// An apt repository SHOULD be pushed as an OCI 1.1 artifact with ArtifactTypeAptRepository,
+// e.g., `oras push --artifact-type application/vnd.debian.apt.repository.v1 IMAGE FILE1 FILE2 ...`.
+//
+// When an index has manifests with ArtifactTypeAptRepository, only those manifests are used.
+// Otherwise, the manifests of the other known "generic" types are used too, for compatibility,
+// and the manifests that are clearly unrelated to apt (signatures, SBOMs, attestations, Helm charts, ...)
+// are ignored.
+
+// ArtifactTypeAptRepository is the artifact type of the apt repositories.
+const ArtifactTypeAptRepository = repoconfig.ArtifactType
+
+// Generic artifact types and config media types that do not tell what the manifest is.
+const (
+	// MediaTypeEmptyJSON is the media type of the empty config of OCI 1.1 artifacts.
+	MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+
+	// ArtifactTypeORASUnknown is the default artifact type of ORAS.
+	ArtifactTypeORASUnknown = "application/vnd.unknown.artifact.v1"
+
+	// MediaTypeORASUnknownConfig is the default config media type of ORAS with `--image-spec=v1.0`.
+	MediaTypeORASUnknownConfig = "application/vnd.unknown.config.v1+json"
+)
+
+// annotationDockerReferenceType is the annotation of the attestation manifests of BuildKit in an index.
+const annotationDockerReferenceType = "vnd.docker.reference.type"
+
+// artifactType returns the artifact type of the manifest.
+// The config media type is the artifact type when the manifest has no artifactType, as in the OCI image spec.
+// The manifests with the repository config are apt repositories.
+func artifactType(manifest *ocispec.Manifest) string {
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType
+	}
+	switch manifest.Config.MediaType {
+	case MediaTypeEmptyJSON:
+		return ""
+	case repoconfig.MediaType:
+		return ArtifactTypeAptRepository
+	}
+	return manifest.Config.MediaType
+}
+
+// isGenericArtifactType returns true if the artifact type does not tell what the manifest is.
+func isGenericArtifactType(t string) bool {
+	switch t {
+	case "", ArtifactTypeAptRepository, ArtifactTypeORASUnknown, MediaTypeORASUnknownConfig,
+		ocispec.MediaTypeImageConfig, images.MediaTypeDockerSchema2Config:
+		return true
+	default:
+		return false
+	}
+}
+
+// isUnrelatedManifest returns true if the manifest is clearly unrelated to apt.
+// The manifests with a subject are the referrers, such as signatures and SBOMs.
+func isUnrelatedManifest(manifest *ocispec.Manifest) bool {
+	t := artifactType(manifest)
+	if t == ArtifactTypeAptRepository {
+		return false
+	}
+	return manifest.Subject != nil || !isGenericArtifactType(t)
+}
+
+// selectManifests selects the manifests of an index to build the file map from.
+func selectManifests(manifests []ocispec.Descriptor) []ocispec.Descriptor {
+	var apt, others []ocispec.Descriptor
+	for _, desc := range manifests {
+		switch {
+		case desc.ArtifactType == ArtifactTypeAptRepository:
+			apt = append(apt, desc)
+		case !isGenericArtifactType(desc.ArtifactType):
+			// Signatures, SBOMs, ...
+		case desc.Annotations[annotationDockerReferenceType] == "attestation-manifest":
+			// Attestations of BuildKit
+		default:
+			others = append(others, desc)
+		}
+	}
+	if len(apt) > 0 {
+		return apt
+	}
+	return others
+}
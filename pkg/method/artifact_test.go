@@ -0,0 +1,104 @@
+package method
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBuildFileMapArtifactTypes(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	empty := f.add(MediaTypeEmptyJSON, []byte("{}"))
+	addManifest := func(artifactType, title string, subject *ocispec.Descriptor) ocispec.Descriptor {
+		layer := f.add(MediaTypeApplicationOctetStream, []byte(title))
+		layer.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+		return f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			MediaType:    ocispec.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Config:       empty,
+			Layers:       []ocispec.Descriptor{layer},
+			Subject:      subject,
+		})
+	}
+	files := func(root ocispec.Descriptor) []string {
+		fm, err := buildFileMap(ctx, f, root, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slices.Sorted(maps.Keys(fm.files))
+	}
+	addIndex := func(manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		return f.addJSON(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+	}
+
+	apt := addManifest(ArtifactTypeAptRepository, "InRelease", nil)
+	apt.ArtifactType = ArtifactTypeAptRepository
+	untyped := addManifest("", "untyped", nil)
+	sig := addManifest("application/vnd.dev.cosign.artifact.sig.v1+json", "sig", &apt)
+	sbom := addManifest("application/spdx+json", "sbom.json", nil)
+	attestation := addManifest("", "attestation", nil)
+	attestation.Annotations = map[string]string{annotationDockerReferenceType: "attestation-manifest"}
+
+	// Only the manifests of the apt artifact type are used
+	if got := files(addIndex(apt, untyped, sig)); !slices.Equal(got, []string{"InRelease"}) {
+		t.Fatalf("unexpected files: %v", got)
+	}
+	// The unrelated manifests are ignored, even without the artifact types in the index
+	if got := files(addIndex(untyped, sig, sbom, attestation)); !slices.Equal(got, []string{"untyped"}) {
+		t.Fatalf("unexpected files: %v", got)
+	}
+	// The manifests with the repository config are apt repositories, even without the artifact type
+	layer := f.add(MediaTypeApplicationOctetStream, []byte("Release"))
+	layer.Annotations = map[string]string{ocispec.AnnotationTitle: "dists/stable/Release"}
+	configured := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    f.addJSON(t, repoconfig.MediaType, repoconfig.Config{Suites: []string{"stable"}}),
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	fm, err := buildFileMap(ctx, f, addIndex(configured, sbom), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fm.files["dists/stable/Release"]; !ok || fm.repoConfig == nil || !fm.repoConfig.HasSuite("stable") {
+		t.Fatalf("unexpected file map: %+v", fm)
+	}
+
+	// An unrelated root manifest is an error
+	if _, err := buildFileMap(ctx, f, sbom, nil); err == nil {
+		t.Fatal("expected an error for an unrelated root manifest")
+	}
+}
+
+func TestArtifactType(t *testing.T) {
+	testCases := []struct {
+		manifest ocispec.Manifest
+		expected string
+	}{
+		{
+			manifest: ocispec.Manifest{ArtifactType: ArtifactTypeAptRepository, Config: ocispec.Descriptor{MediaType: MediaTypeEmptyJSON}},
+			expected: ArtifactTypeAptRepository,
+		},
+		{
+			manifest: ocispec.Manifest{Config: ocispec.Descriptor{MediaType: MediaTypeEmptyJSON}},
+			expected: "",
+		},
+		{
+			manifest: ocispec.Manifest{Config: ocispec.Descriptor{MediaType: "application/vnd.cncf.helm.config.v1+json", Digest: digest.FromString("{}")}},
+			expected: "application/vnd.cncf.helm.config.v1+json",
+		},
+	}
+	for _, tc := range testCases {
+		if got := artifactType(&tc.manifest); got != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, got)
+		}
+	}
+}
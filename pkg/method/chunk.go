@@ -0,0 +1,193 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// A file larger than the blob size limit of the registry can be split into multiple layers ("parts").
+// The parts have the same "org.opencontainers.image.title" annotation, and the following annotations:
+//
+//	"dev.apt-transport-oci.part.index": "0"
+//	"dev.apt-transport-oci.part.count": "3"
+//	"dev.apt-transport-oci.file.digest": "sha256:<hex of the whole file>"
+//
+// The parts are concatenated in the order of the index.
+// Each part is verified with its own digest, and the whole file is verified with the file digest.
+const (
+	AnnotationPartIndex  = "dev.apt-transport-oci.part.index"
+	AnnotationPartCount  = "dev.apt-transport-oci.part.count"
+	AnnotationFileDigest = "dev.apt-transport-oci.file.digest"
+)
+
+// chunkedFile is a file split into parts.
+type chunkedFile struct {
+	digest digest.Digest
+	// parts has nil elements for the missing parts
+	parts []*ocispec.Descriptor
+}
+
+// isPart returns true if the layer is a part of a chunked file.
+func isPart(l ocispec.Descriptor) bool {
+	_, ok := l.Annotations[AnnotationPartCount]
+	return ok
+}
+
+// addPart adds the layer l to the chunked file of title in chunked.
+func addPart(chunked map[string]*chunkedFile, title string, l ocispec.Descriptor) error {
+	index, err := strconv.Atoi(l.Annotations[AnnotationPartIndex])
+	if err != nil {
+		return fmt.Errorf("invalid %s of %q: %w", AnnotationPartIndex, title, err)
+	}
+	count, err := strconv.Atoi(l.Annotations[AnnotationPartCount])
+	if err != nil {
+		return fmt.Errorf("invalid %s of %q: %w", AnnotationPartCount, title, err)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return fmt.Errorf("invalid part %d/%d of %q", index, count, title)
+	}
+	dgst, err := digest.Parse(l.Annotations[AnnotationFileDigest])
+	if err != nil {
+		return fmt.Errorf("invalid %s of %q: %w", AnnotationFileDigest, title, err)
+	}
+	cf, ok := chunked[title]
+	if !ok {
+		cf = &chunkedFile{digest: dgst, parts: make([]*ocispec.Descriptor, count)}
+		chunked[title] = cf
+	}
+	if cf.digest != dgst || len(cf.parts) != count {
+		return fmt.Errorf("inconsistent parts of %q", title)
+	}
+	if p := cf.parts[index]; p != nil && p.Digest != l.Digest {
+		return fmt.Errorf("conflicting part %d/%d of %q", index, count, title)
+	}
+	cf.parts[index] = &l
+	return nil
+}
+
+// assemble returns the descriptor of the whole file, and the descriptors of the parts.
+func (cf *chunkedFile) assemble(title string) (ocispec.Descriptor, []ocispec.Descriptor, error) {
+	desc := ocispec.Descriptor{
+		Digest: cf.digest,
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle: title,
+		},
+	}
+	parts := make([]ocispec.Descriptor, len(cf.parts))
+	for i, p := range cf.parts {
+		if p == nil {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("missing part %d/%d of %q", i, len(cf.parts), title)
+		}
+		if p.Size < 0 {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("invalid size of part %d/%d of %q", i, len(cf.parts), title)
+		}
+		parts[i] = *p
+		desc.Size += p.Size
+	}
+	desc.MediaType = parts[0].MediaType
+	return desc, parts, nil
+}
+
+// fetchParts fetches the parts of the chunked file desc, and concatenates them.
+// The first part is fetched immediately, so that the errors are reported before "200 URI Start".
+func (m *Method) fetchParts(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor, parts []ocispec.Descriptor) (io.ReadCloser, *mirror, error) {
+	m.Statusf(uri, "Fetching %d parts of %s", len(parts), desc.Digest)
+	var usedMirror *mirror
+	open := func(part ocispec.Descriptor) (io.ReadCloser, error) {
+		r, mi, err := m.fetchBlob(ctx, uri, c, part)
+		if usedMirror == nil {
+			usedMirror = mi
+		}
+		return r, err
+	}
+	pr := &partsReader{
+		open:     open,
+		parts:    parts,
+		verifier: desc.Digest.Verifier(),
+		desc:     desc,
+	}
+	if err := pr.next(); err != nil {
+		return nil, usedMirror, err
+	}
+	return pr, usedMirror, nil
+}
+
+// partsReader concatenates the parts, verifying each part and the whole file.
+type partsReader struct {
+	open     func(ocispec.Descriptor) (io.ReadCloser, error)
+	parts    []ocispec.Descriptor
+	verifier digest.Verifier
+	desc     ocispec.Descriptor
+
+	// i is the index of the current part
+	i        int
+	cur      io.ReadCloser
+	curVerif digest.Verifier
+	curSize  int64
+}
+
+// next opens the part i.
+func (pr *partsReader) next() error {
+	part := pr.parts[pr.i]
+	r, err := pr.open(part)
+	if err != nil {
+		return fmt.Errorf("failed to fetch part %d/%d: %w", pr.i, len(pr.parts), err)
+	}
+	pr.cur, pr.curVerif, pr.curSize = r, part.Digest.Verifier(), 0
+	return nil
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.cur == nil {
+			if pr.i == len(pr.parts) {
+				if !pr.verifier.Verified() {
+					return 0, fmt.Errorf("digest mismatch of the reassembled file, expected %s", pr.desc.Digest)
+				}
+				return 0, io.EOF
+			}
+			if err := pr.next(); err != nil {
+				return 0, err
+			}
+		}
+		part := pr.parts[pr.i]
+		n, err := pr.cur.Read(p)
+		if n > 0 {
+			pr.curSize += int64(n)
+			if pr.curSize > part.Size {
+				return 0, fmt.Errorf("part %d/%d is larger than %d bytes", pr.i, len(pr.parts), part.Size)
+			}
+			pr.curVerif.Write(p[:n])
+			pr.verifier.Write(p[:n])
+		}
+		if err == io.EOF {
+			if pr.curSize != part.Size || !pr.curVerif.Verified() {
+				return 0, fmt.Errorf("part %d/%d does not match the descriptor %s", pr.i, len(pr.parts), part.Digest)
+			}
+			if err := pr.cur.Close(); err != nil {
+				return 0, err
+			}
+			pr.cur = nil
+			pr.i++
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.cur == nil {
+		return nil
+	}
+	err := pr.cur.Close()
+	pr.cur = nil
+	return err
+}
@@ -0,0 +1,91 @@
+package method
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestChunkedFile(t *testing.T) {
+	const title = "pool/main/h/hello/hello-dbgsym_1.0_amd64.deb"
+	contents := []string{"hello, ", "chunked ", "world"}
+	whole := digest.FromString(contents[0] + contents[1] + contents[2])
+	blobs := make(map[digest.Digest][]byte)
+	layer := func(i int, content string) ocispec.Descriptor {
+		dgst := digest.FromString(content)
+		blobs[dgst] = []byte(content)
+		return ocispec.Descriptor{
+			MediaType: MediaTypeApplicationOctetStream,
+			Digest:    dgst,
+			Size:      int64(len(content)),
+			Annotations: map[string]string{
+				ocispec.AnnotationTitle: title,
+				AnnotationPartIndex:     strconv.Itoa(i),
+				AnnotationPartCount:     strconv.Itoa(len(contents)),
+				AnnotationFileDigest:    whole.String(),
+			},
+		}
+	}
+
+	chunked := make(map[string]*chunkedFile)
+	// The parts may appear in any order, and may be duplicated across the manifests of an index
+	for _, i := range []int{2, 0, 1, 0} {
+		l := layer(i, contents[i])
+		if !isPart(l) {
+			t.Fatalf("expected part %d to be a part", i)
+		}
+		if err := addPart(chunked, title, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	desc, parts, err := chunked[title].assemble(title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != whole || desc.Size != int64(len("hello, chunked world")) || len(parts) != len(contents) {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+
+	open := func(d ocispec.Descriptor) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(blobs[d.Digest])), nil
+	}
+	pr := &partsReader{open: open, parts: parts, verifier: desc.Digest.Verifier(), desc: desc}
+	if err := pr.next(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello, chunked world" {
+		t.Fatalf("unexpected content: %q", b)
+	}
+
+	// A tampered part is detected
+	blobs[parts[1].Digest] = []byte("CHUNKED ")
+	pr = &partsReader{open: open, parts: parts, verifier: desc.Digest.Verifier(), desc: desc}
+	if err := pr.next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(pr); err == nil {
+		t.Fatal("expected an error for the tampered part")
+	}
+
+	// A missing part is detected
+	chunked = make(map[string]*chunkedFile)
+	if err := addPart(chunked, title, layer(0, contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := chunked[title].assemble(title); err == nil {
+		t.Fatal("expected an error for the missing parts")
+	}
+
+	// A conflicting part is detected
+	if err := addPart(chunked, title, layer(0, "conflict")); err == nil {
+		t.Fatal("expected an error for the conflicting part")
+	}
+}
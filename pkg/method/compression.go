@@ -0,0 +1,128 @@
+package method
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression algorithms
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	// compressionXz is only used for decompressing the control archives of the .deb files
+	compressionXz = "xz"
+	// compressionUnknown is detected from the magic number
+	compressionUnknown = "unknown"
+)
+
+// AnnotationCompression is the annotation of a layer that specifies the compression ("gzip" or "zstd") of the file.
+// The media type suffix ("+gzip" or "+zstd") can be used instead, e.g., "application/octet-stream+zstd".
+// The compressed layers are decompressed on the fly.
+const AnnotationCompression = "dev.apt-transport-oci.compression"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns the reader that decompresses r.
+func decompress(r io.Reader, compression string) (io.ReadCloser, error) {
+	if compression == compressionUnknown {
+		br := bufio.NewReader(r)
+		magic, _ := br.Peek(len(zstdMagic))
+		switch {
+		case bytes.HasPrefix(magic, gzipMagic):
+			compression = compressionGzip
+		case bytes.HasPrefix(magic, zstdMagic):
+			compression = compressionZstd
+		default:
+			compression = compressionNone
+		}
+		r = br
+	}
+	switch compression {
+	case compressionNone:
+		return io.NopCloser(r), nil
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case compressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// compressGzip returns the reader that compresses r with gzip.
+// The encoder is deterministic: the same input always produces the same output
+// with the same version of the encoder, as the level is fixed and the header is empty.
+func compressGzip(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// No file name and no modification time in the header
+		gw, err := gzip.NewWriterLevel(pw, gzip.BestCompression)
+		if err == nil {
+			_, err = io.Copy(gw, r)
+			err = errors.Join(err, gw.Close())
+		}
+		pw.CloseWithError(err)
+	}()
+	return &readCloser{Reader: pr, close: func() error {
+		// Wait for the encoder to stop reading r, so that r can be closed by the caller
+		err := pr.Close()
+		<-done
+		return err
+	}}
+}
+
+// baseMediaType returns the media type without the suffix, e.g., "application/octet-stream+zstd" -> "application/octet-stream".
+func baseMediaType(mediaType string) string {
+	base, _, _ := strings.Cut(mediaType, "+")
+	return base
+}
+
+// compressionExtensions maps the compressions to the file name extensions.
+var compressionExtensions = map[string]string{
+	compressionGzip: ".gz",
+	compressionZstd: ".zst",
+}
+
+// rawLayerCompression returns the compression of a layer that is not a tar layer.
+// The media type suffix is only recognized for "application/octet-stream" and "application/x-binary".
+// A layer titled with the extension of the compression (e.g., "Packages.gz") is not decompressed,
+// as the compressed file is the file itself.
+func rawLayerCompression(desc ocispec.Descriptor) (string, bool) {
+	compression := desc.Annotations[AnnotationCompression]
+	if compression == "" {
+		switch base, suffix, _ := strings.Cut(desc.MediaType, "+"); base {
+		case MediaTypeApplicationOctetStream, MediaTypeApplicationXBinary:
+			compression = suffix
+		}
+	}
+	ext, ok := compressionExtensions[compression]
+	if !ok || strings.HasSuffix(desc.Annotations[ocispec.AnnotationTitle], ext) {
+		return "", false
+	}
+	return compression, true
+}
@@ -0,0 +1,78 @@
+package method
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRawLayerCompression(t *testing.T) {
+	testCases := []struct {
+		desc        ocispec.Descriptor
+		compression string
+		ok          bool
+	}{
+		{
+			desc: ocispec.Descriptor{MediaType: MediaTypeApplicationOctetStream},
+		},
+		{
+			desc:        ocispec.Descriptor{MediaType: MediaTypeApplicationOctetStream + "+zstd"},
+			compression: compressionZstd,
+			ok:          true,
+		},
+		{
+			desc:        ocispec.Descriptor{MediaType: MediaTypeApplicationXBinary + "+gzip"},
+			compression: compressionGzip,
+			ok:          true,
+		},
+		{
+			desc: ocispec.Descriptor{
+				MediaType:   MediaTypeApplicationOctetStream,
+				Annotations: map[string]string{AnnotationCompression: "gzip"},
+			},
+			compression: compressionGzip,
+			ok:          true,
+		},
+		{
+			desc: ocispec.Descriptor{MediaType: MediaTypeApplicationOctetStream + "+bzip2"},
+		},
+		{
+			desc: ocispec.Descriptor{
+				MediaType:   MediaTypeApplicationOctetStream,
+				Annotations: map[string]string{AnnotationCompression: "xz"},
+			},
+		},
+		{
+			desc: ocispec.Descriptor{
+				MediaType:   MediaTypeApplicationOctetStream + "+gzip",
+				Annotations: map[string]string{ocispec.AnnotationTitle: "dists/bookworm/main/binary-amd64/Packages.gz"},
+			},
+		},
+		{
+			desc: ocispec.Descriptor{
+				MediaType:   MediaTypeApplicationOctetStream,
+				Annotations: map[string]string{ocispec.AnnotationTitle: "Packages.zst", AnnotationCompression: "zstd"},
+			},
+		},
+		{
+			desc: ocispec.Descriptor{
+				MediaType:   MediaTypeApplicationOctetStream + "+gzip",
+				Annotations: map[string]string{ocispec.AnnotationTitle: "Packages.zst"},
+			},
+			compression: compressionGzip,
+			ok:          true,
+		},
+		{
+			desc: ocispec.Descriptor{MediaType: "application/vnd.example+zstd"},
+		},
+	}
+	for _, tc := range testCases {
+		compression, ok := rawLayerCompression(tc.desc)
+		if compression != tc.compression || ok != tc.ok {
+			t.Errorf("%+v: expected (%q, %v), got (%q, %v)", tc.desc, tc.compression, tc.ok, compression, ok)
+		}
+	}
+	if mt := baseMediaType(MediaTypeApplicationOctetStream + "+zstd"); mt != MediaTypeApplicationOctetStream {
+		t.Errorf("unexpected base media type %q", mt)
+	}
+}
@@ -0,0 +1,108 @@
+package method
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/apt"
+)
+
+// Config items, sent by apt in the "601 Configuration" message.
+// The keys are case-insensitive.
+const (
+	// ConfigHostsDir is the directory that contains "<host>/hosts.toml" files.
+	// See https://github.com/containerd/containerd/blob/main/docs/hosts.md
+	ConfigHostsDir = "Acquire::oci::HostsDir"
+
+	// ConfigAllowPlainHTTP is the list of the hosts (such as "registry.example.com:5000")
+	// that are allowed to be used with "oci+http://".
+	ConfigAllowPlainHTTP = "Acquire::oci::AllowPlainHTTP"
+)
+
+// DefaultHostsDir is the default value of ConfigHostsDir.
+const DefaultHostsDir = "/etc/containerd/certs.d"
+
+// config is the apt configuration.
+// The keys are lower-cased.
+// A key may have multiple values when the item is a list.
+type config map[string][]string
+
+// parseConfig parses the "Config-Item" fields like "Acquire::oci::HostsDir=/etc/containerd/certs.d".
+// The items are quoted with apt's QuoteString.
+func parseConfig(msg *apt.Message) config {
+	c := make(config)
+	for _, item := range strings.Split(msg.Fields[FieldConfigItem], "\n") {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := url.PathUnescape(k); err == nil {
+			k = unquoted
+		}
+		if unquoted, err := url.PathUnescape(v); err == nil {
+			v = unquoted
+		}
+		// List items are like "Acquire::oci::Foo::=bar"
+		k = strings.ToLower(strings.TrimSuffix(k, "::"))
+		c[k] = append(c[k], v)
+	}
+	return c
+}
+
+// Get returns the last value of the key.
+func (c config) Get(key string) string {
+	values := c[strings.ToLower(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// GetDefault returns the last value of the key, or defaultValue.
+func (c config) GetDefault(key, defaultValue string) string {
+	if v := c.Get(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// Values returns all the values of the key.
+func (c config) Values(key string) []string {
+	return c[strings.ToLower(key)]
+}
+
+// allowsPlainHTTP returns true if host is listed in ConfigAllowPlainHTTP.
+func (c config) allowsPlainHTTP(host string) bool {
+	for _, v := range c.Values(ConfigAllowPlainHTTP) {
+		if strings.EqualFold(v, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirDefaults is the defaults of apt's directory items.
+var dirDefaults = map[string]string{
+	"dir":               "/",
+	"dir::state":        "var/lib/apt/",
+	"dir::state::lists": "lists/",
+}
+
+// findDir resolves a directory item like apt's FindDir.
+// e.g., "Dir::State::lists" is resolved to "/var/lib/apt/lists" by default.
+func (c config) findDir(key string) string {
+	v := c.GetDefault(key, dirDefaults[strings.ToLower(key)])
+	if filepath.IsAbs(v) {
+		return v
+	}
+	i := strings.LastIndex(key, "::")
+	if i < 0 {
+		return v
+	}
+	return filepath.Join(c.findDir(key[:i]), v)
+}
+
+func (m *Method) handleConfiguration(msg *apt.Message) {
+	m.config = parseConfig(msg)
+}
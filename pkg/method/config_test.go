@@ -0,0 +1,34 @@
+package method
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/apt"
+)
+
+func TestParseConfig(t *testing.T) {
+	msg := &apt.Message{
+		StatusCode:  CodeConfiguration,
+		Description: "Configuration",
+		Fields: map[string]string{
+			FieldConfigItem: "Acquire::oci::HostsDir=/etc/apt-transport-oci/certs.d\n" +
+				"Acquire::oci::Foo::=a\n" +
+				"Acquire::oci::Foo::=b\n" +
+				"Acquire::oci::Bar%3dBaz=c%3dd",
+		},
+	}
+	c := parseConfig(msg)
+	if got := c.Get("acquire::OCI::hostsdir"); got != "/etc/apt-transport-oci/certs.d" {
+		t.Fatalf("unexpected HostsDir: %q", got)
+	}
+	if got := c.Values("Acquire::oci::Foo"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected Foo: %v", got)
+	}
+	if got := c.Get("Acquire::oci::Bar=Baz"); got != "c=d" {
+		t.Fatalf("unexpected Bar=Baz: %q", got)
+	}
+	if got := c.GetDefault("Acquire::oci::Missing", "default"); got != "default" {
+		t.Fatalf("unexpected Missing: %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	refdocker "github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AnnotationSourceRepository is the annotation of a layer that specifies the repository that has the blob,
+// such as "ghcr.io/foo/pool". The repository may be on another registry.
+// This allows sharing a large "pool/" between the repositories without uploading the blobs to each of them.
+//
+// The annotation of a manifest applies to all the layers of the manifest that lack the annotation.
+//
+// The blob is fetched by the digest, so the root digest still covers the content of the file.
+const AnnotationSourceRepository = "dev.apt-transport-oci.source-repository"
+
+// sourceRepository returns the reference of desc in the repository specified by AnnotationSourceRepository,
+// such as "ghcr.io/foo/pool@sha256:<hex>".
+func sourceRepository(desc ocispec.Descriptor) (refdocker.Named, bool, error) {
+	s, ok := desc.Annotations[AnnotationSourceRepository]
+	if !ok {
+		return nil, false, nil
+	}
+	named, err := refdocker.ParseNormalizedNamed(s)
+	if err == nil && !refdocker.IsNameOnly(named) {
+		err = fmt.Errorf("must not have a tag or a digest")
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s annotation %q: %w", AnnotationSourceRepository, s, err)
+	}
+	ref, err := refdocker.WithDigest(named, desc.Digest)
+	if err != nil {
+		return nil, false, err
+	}
+	return ref, true, nil
+}
+
+// plainHTTP returns true if a mirror or an overlay of c on the domain uses plain HTTP.
+func (c *cacheByOCIRef) plainHTTP(domain string) bool {
+	for _, mi := range c.mirrors {
+		if mi.plainHTTP && refdocker.Domain(mi.ref) == domain {
+			return true
+		}
+	}
+	for _, ov := range c.overlays {
+		if ov.plainHTTP && refdocker.Domain(ov.ref) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFromSourceRepository fetches desc from ref, which is returned by sourceRepository.
+// ref is checked against the policy. The signature requirement does not apply,
+// as the blob is covered by the root digest.
+func (m *Method) fetchFromSourceRepository(ctx context.Context, uri string, c *cacheByOCIRef, ref refdocker.Named, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	pol, err := m.policy()
+	if err != nil {
+		return nil, err
+	}
+	if err := pol.check(ref); err != nil {
+		return nil, err
+	}
+	fetcher, ok := m.sourceRepositoryFetchers[ref.Name()]
+	if !ok {
+		domain := refdocker.Domain(ref)
+		plainHTTP := c.plainHTTP(domain)
+		if plainHTTP && !m.config.allowsPlainHTTP(domain) {
+			return nil, fmt.Errorf("plain HTTP is not allowed for %q (Hint: add %q to %s)", domain, domain, ConfigAllowPlainHTTP)
+		}
+		m.Statusf(uri, "Creating a resolver for the source repository %q", ref.Name())
+		var resolver remotes.Resolver
+		resolver, err = m.ociResolver(ref, plainHTTP)
+		if err == nil {
+			fetcher, err = resolver.Fetcher(ctx, ref.String())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("source repository %q: %w", ref.Name(), err)
+		}
+		m.sourceRepositoryFetchers[ref.Name()] = fetcher
+	}
+	m.Statusf(uri, "Fetching %s from the source repository %q", desc.Digest, ref.Name())
+	return fetcher.Fetch(ctx, desc)
+}
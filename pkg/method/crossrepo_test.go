@@ -0,0 +1,36 @@
+package method
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSourceRepository(t *testing.T) {
+	dgst := digest.FromString("hello")
+	desc := func(repo string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			Digest:      dgst,
+			Annotations: map[string]string{AnnotationSourceRepository: repo},
+		}
+	}
+
+	if _, ok, err := sourceRepository(ocispec.Descriptor{Digest: dgst}); ok || err != nil {
+		t.Fatalf("expected no source repository, got ok=%v, err=%v", ok, err)
+	}
+
+	ref, ok, err := sourceRepository(desc("ghcr.io/foo/pool"))
+	if err != nil || !ok {
+		t.Fatalf("expected a source repository, got ok=%v, err=%v", ok, err)
+	}
+	if expected := "ghcr.io/foo/pool@" + dgst.String(); ref.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, ref)
+	}
+
+	for _, repo := range []string{"ghcr.io/foo/pool:latest", "ghcr.io/foo/Pool", ""} {
+		if _, _, err := sourceRepository(desc(repo)); err == nil {
+			t.Fatalf("expected an error for %q", repo)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package method
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/semaphore"
+)
+
+// The file map of an index with many manifests is built in the background,
+// with up to maxConcurrentManifests manifests fetched concurrently.
+// The files of each manifest are added to the file map as soon as the manifest is parsed,
+// so that a lookup does not have to wait for the other manifests.
+//
+// The chunked files are added when all the manifests are parsed, as their parts may span the manifests.
+//
+// When a title is found in multiple manifests, the manifest at the lower position in the index takes precedence,
+// regardless of the order of completion. So a lookup waits while a manifest at a lower position is pending.
+
+// maxConcurrentManifests is the maximum number of the manifests fetched concurrently.
+const maxConcurrentManifests = 8
+
+// position is the position of a manifest in the tree of the indexes,
+// e.g., [1 0] for the first manifest of the second index in the root index.
+type position []int
+
+// less returns true if p takes precedence over q.
+func (p position) less(q position) bool {
+	return slices.Compare(p, q) < 0
+}
+
+// fileMap is the map of the files of an artifact.
+type fileMap struct {
+	// files maps the titles to the descriptors
+	files map[string]ocispec.Descriptor
+	// filePos maps the titles to the positions of the manifests that have them.
+	// It is empty when the build is done.
+	filePos map[string]position
+	// pending maps the digests of the manifests and the indexes that are not parsed yet to their positions
+	pending map[digest.Digest][]position
+	// parts maps the digests of the chunked files to their parts.
+	// See chunk.go.
+	parts map[digest.Digest][]ocispec.Descriptor
+	// entries maps the digests of the files in the tar layers to the entries.
+	// See tarlayer.go.
+	entries map[digest.Digest]tarEntry
+	// generated maps the digests of the files generated in memory to their contents.
+	// See flatrepo.go.
+	generated map[digest.Digest][]byte
+	// repoConfig is the repository config in the config blobs, if any.
+	// It is set when the build is finished. See suite.go and repoconfig.go.
+	repoConfig *repoconfig.Config
+
+	// mu guards the fields above while building is true
+	mu sync.Mutex
+	// cond is broadcast when the files of a manifest are added, and when the build is done
+	cond     *sync.Cond
+	building bool
+	// err is the error of the build
+	err error
+}
+
+// newFileMap returns an empty file map that is not being built.
+func newFileMap() *fileMap {
+	fm := &fileMap{
+		files:   make(map[string]ocispec.Descriptor),
+		filePos: make(map[string]position),
+		pending: make(map[digest.Digest][]position),
+		parts:   make(map[digest.Digest][]ocispec.Descriptor),
+		entries: make(map[digest.Digest]tarEntry),
+	}
+	fm.cond = sync.NewCond(&fm.mu)
+	return fm
+}
+
+// blobs returns the digests of the blobs that serve the file desc.
+func (fm *fileMap) blobs(desc ocispec.Descriptor) []digest.Digest {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if parts, ok := fm.parts[desc.Digest]; ok {
+		dgsts := make([]digest.Digest, len(parts))
+		for i, p := range parts {
+			dgsts[i] = p.Digest
+		}
+		return dgsts
+	}
+	if e, ok := fm.entries[desc.Digest]; ok {
+		return []digest.Digest{e.layer.Digest}
+	}
+	return []digest.Digest{desc.Digest}
+}
+
+// wait waits for the build to finish, and returns its error.
+func (fm *fileMap) wait() error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for fm.building {
+		fm.cond.Wait()
+	}
+	return fm.err
+}
+
+// lookup returns the descriptor of title, as soon as a manifest that has title is parsed
+// and no manifest at a lower position is pending.
+// When accept returns false for the descriptor, lookup waits for the build to finish,
+// and returns the last descriptor found.
+// ok is false when title is not found after the build is finished.
+func (fm *fileMap) lookup(title string, accept func(ocispec.Descriptor) bool) (desc ocispec.Descriptor, ok bool, err error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for {
+		desc, ok = fm.files[title]
+		if ok && accept(desc) && !fm.pendingBefore(fm.filePos[title]) {
+			return desc, true, nil
+		}
+		if !fm.building {
+			if ok {
+				return desc, true, nil
+			}
+			return ocispec.Descriptor{}, false, fm.err
+		}
+		fm.cond.Wait()
+	}
+}
+
+// pendingBefore returns true if a manifest or an index at a lower position than pos is pending.
+// fm.mu has to be locked.
+func (fm *fileMap) pendingBefore(pos position) bool {
+	for _, positions := range fm.pending {
+		for _, p := range positions {
+			if p.less(pos) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// source returns how the file desc is served: the parts, the tar entry, or the generated content.
+func (fm *fileMap) source(desc ocispec.Descriptor) (parts []ocispec.Descriptor, e *tarEntry, generated []byte) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if b, ok := fm.generated[desc.Digest]; ok {
+		return nil, nil, b
+	}
+	if parts, ok := fm.parts[desc.Digest]; ok {
+		return parts, nil, nil
+	}
+	if e, ok := fm.entries[desc.Digest]; ok {
+		return nil, &e, nil
+	}
+	return nil, nil, nil
+}
+
+// config returns the repository config.
+func (fm *fileMap) config() *repoconfig.Config {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.repoConfig
+}
+
+// buildFileMap builds the file map of rootDesc, and waits for the build to finish.
+// The files in the tar layers are kept in sp, if sp is not nil.
+func buildFileMap(ctx context.Context, fetcher remotes.Fetcher, rootDesc ocispec.Descriptor, sp *spool) (*fileMap, error) {
+	fm := startFileMap(ctx, fetcher, rootDesc, sp)
+	if err := fm.wait(); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
+
+// startFileMap starts building the file map of rootDesc in the background.
+// The files in the tar layers are kept in sp, if sp is not nil.
+func startFileMap(ctx context.Context, fetcher remotes.Fetcher, rootDesc ocispec.Descriptor, sp *spool) *fileMap {
+	fm := newFileMap()
+	fm.building = true
+	fm.pending[rootDesc.Digest] = []position{{}}
+	b := &fileMapBuilder{
+		fm:        fm,
+		fetcher:   fetcher,
+		rootDesc:  rootDesc,
+		sp:        sp,
+		chunked:   make(map[string]*chunkedFile),
+		tarLayers: make(map[digest.Digest]*tarLayerResult),
+		configs:   newRepoConfigs(),
+		positions: map[digest.Digest]position{rootDesc.Digest: {}},
+	}
+	go func() {
+		err := images.Dispatch(ctx, images.HandlerFunc(b.handle), semaphore.NewWeighted(maxConcurrentManifests), rootDesc)
+		fm.mu.Lock()
+		defer fm.mu.Unlock()
+		if err == nil {
+			err = b.assembleChunked()
+		}
+		fm.repoConfig = b.configs.merged(rootDesc)
+		clear(fm.filePos)
+		clear(fm.pending)
+		fm.err, fm.building = err, false
+		fm.cond.Broadcast()
+	}()
+	return fm
+}
+
+// fileMapBuilder builds a file map.
+type fileMapBuilder struct {
+	fm       *fileMap
+	fetcher  remotes.Fetcher
+	rootDesc ocispec.Descriptor
+	sp       *spool
+
+	// chunked is guarded by fm.mu
+	chunked map[string]*chunkedFile
+	// positions maps the digests of the manifests and the indexes to their lowest positions.
+	// It is guarded by fm.mu.
+	positions map[digest.Digest]position
+
+	// configs collects the repository configs, to merge them when all the manifests are parsed
+	configs *repoConfigs
+
+	// tarLayers caches the tar layers shared by the manifests
+	tarLayersMu sync.Mutex
+	tarLayers   map[digest.Digest]*tarLayerResult
+}
+
+// tarLayerResult is the result of reading a tar layer, which is read once for all the manifests.
+type tarLayerResult struct {
+	once sync.Once
+	tl   *tarLayer
+	err  error
+}
+
+// tarLayer reads the tar layer l, or returns the cached result.
+func (b *fileMapBuilder) tarLayer(ctx context.Context, l ocispec.Descriptor, compression string) (*tarLayer, error) {
+	b.tarLayersMu.Lock()
+	res, ok := b.tarLayers[l.Digest]
+	if !ok {
+		res = &tarLayerResult{}
+		b.tarLayers[l.Digest] = res
+	}
+	b.tarLayersMu.Unlock()
+	res.once.Do(func() {
+		if isSeekableLayer(l) {
+			// Fall back to reading the whole layer on errors
+			if res.tl, res.err = readSeekableLayer(ctx, b.fetcher, l, compression); res.err == nil {
+				return
+			}
+		}
+		res.tl, res.err = readTarLayer(ctx, b.fetcher, l, compression, b.sp)
+		if res.err != nil {
+			res.err = fmt.Errorf("failed to read layer %s: %w", l.Digest, res.err)
+		}
+	})
+	return res.tl, res.err
+}
+
+// partLayer is a part of a chunked file.
+type partLayer struct {
+	title string
+	layer ocispec.Descriptor
+}
+
+func (b *fileMapBuilder) handle(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		err := b.handleManifest(ctx, desc)
+		b.done(desc, nil)
+		return nil, err
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		blob, err := ociutil.ReadBlob(ctx, b.fetcher, desc)
+		if err != nil {
+			return nil, err
+		}
+		var index ocispec.Index
+		if err := json.Unmarshal(blob, &index); err != nil {
+			return nil, err
+		}
+		children := selectManifests(index.Manifests)
+		b.configs.addIndex(desc.Digest, children)
+		b.done(desc, children)
+		return children, nil
+	}
+	return nil, nil
+}
+
+// done marks desc as parsed, and the children of desc as pending.
+func (b *fileMapBuilder) done(desc ocispec.Descriptor, children []ocispec.Descriptor) {
+	fm := b.fm
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for _, parent := range fm.pending[desc.Digest] {
+		for i, child := range children {
+			pos := append(slices.Clone(parent), i)
+			fm.pending[child.Digest] = append(fm.pending[child.Digest], pos)
+			if prev, ok := b.positions[child.Digest]; !ok || pos.less(prev) {
+				b.positions[child.Digest] = pos
+			}
+		}
+	}
+	delete(fm.pending, desc.Digest)
+	fm.cond.Broadcast()
+}
+
+// handleManifest adds the files of the manifest desc to the file map.
+func (b *fileMapBuilder) handleManifest(ctx context.Context, desc ocispec.Descriptor) error {
+	blob, err := ociutil.ReadBlob(ctx, b.fetcher, desc)
+	if err != nil {
+		return err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return err
+	}
+	if isUnrelatedManifest(&manifest) {
+		if desc.Digest == b.rootDesc.Digest {
+			return fmt.Errorf("unexpected artifact type %q of %s", artifactType(&manifest), desc.Digest)
+		}
+		return nil
+	}
+	rc, err := readManifestRepoConfig(ctx, b.fetcher, &manifest)
+	if err != nil {
+		return err
+	}
+	if rc != nil {
+		b.configs.addManifest(desc.Digest, rc)
+	}
+	fs := newRootfs()
+	entries := make(map[digest.Digest]tarEntry)
+	var parts []partLayer
+	for _, l := range manifest.Layers {
+		title := l.Annotations[ocispec.AnnotationTitle]
+		orasDir := title != "" && l.Annotations[AnnotationORASContentUnpack] == "true"
+		if title == "" || orasDir {
+			compression, ok := tarLayerCompression(l.MediaType)
+			if !ok {
+				continue
+			}
+			tl, err := b.tarLayer(ctx, l, compression)
+			if err != nil {
+				return err
+			}
+			if orasDir {
+				tl = tl.sub(path.Clean(title))
+			}
+			fs.applyTarLayer(tl, entries)
+			continue
+		}
+		repo, ok := manifest.Annotations[AnnotationSourceRepository]
+		if _, layerOK := l.Annotations[AnnotationSourceRepository]; ok && !layerOK {
+			l.Annotations = maps.Clone(l.Annotations)
+			l.Annotations[AnnotationSourceRepository] = repo
+		}
+		cleanPath := path.Clean(title)
+		if isPart(l) {
+			parts = append(parts, partLayer{title: cleanPath, layer: l})
+			continue
+		}
+		fs.add(cleanPath, l)
+	}
+	files := fs.resolve()
+
+	fm := b.fm
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for _, p := range parts {
+		if err := addPart(b.chunked, p.title, p.layer); err != nil {
+			return err
+		}
+	}
+	maps.Copy(fm.entries, entries)
+	pos := b.positions[desc.Digest]
+	for title, f := range files {
+		if prev, ok := fm.filePos[title]; ok && prev.less(pos) {
+			continue
+		}
+		fm.files[title], fm.filePos[title] = f, pos
+	}
+	fm.cond.Broadcast()
+	return nil
+}
+
+// assembleChunked adds the chunked files to the file map.
+// fm.mu has to be locked.
+func (b *fileMapBuilder) assembleChunked() error {
+	fm := b.fm
+	for title, cf := range b.chunked {
+		if _, ok := fm.files[title]; ok {
+			return fmt.Errorf("%q is both a regular file and a chunked file", title)
+		}
+		desc, p, err := cf.assemble(title)
+		if err != nil {
+			return err
+		}
+		fm.files[title], fm.parts[desc.Digest] = desc, p
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package method
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blockingFetcher blocks fetching the blob until the channel is closed.
+type blockingFetcher struct {
+	memFetcher
+	blocked digest.Digest
+	release chan struct{}
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if desc.Digest == f.blocked {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return f.memFetcher.Fetch(ctx, desc)
+}
+
+func TestFileMapLookupWhileBuilding(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	empty := f.add(MediaTypeEmptyJSON, []byte("{}"))
+	addManifest := func(title, content string) ocispec.Descriptor {
+		layer := f.add(MediaTypeApplicationOctetStream, []byte(content))
+		layer.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+		return f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    empty,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+	}
+	fast := addManifest("dists/stable/InRelease", "InRelease")
+	slow := addManifest("dists/stable/main/binary-amd64/Packages", "Packages")
+	root := f.addJSON(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{fast, slow},
+	})
+
+	bf := &blockingFetcher{memFetcher: f, blocked: slow.Digest, release: make(chan struct{})}
+	fm := startFileMap(ctx, bf, root, nil)
+	acceptAny := func(ocispec.Descriptor) bool { return true }
+
+	// InRelease is found without waiting for the slow manifest
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, ok, err := fm.lookup("dists/stable/InRelease", acceptAny); !ok || err != nil {
+			t.Errorf("expected InRelease to be found, got %v, %v", ok, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("lookup waited for the slow manifest")
+	}
+
+	// A file of the slow manifest is found after the manifest is released
+	result := make(chan bool)
+	go func() {
+		_, ok, err := fm.lookup("dists/stable/main/binary-amd64/Packages", acceptAny)
+		result <- ok && err == nil
+	}()
+	select {
+	case <-result:
+		t.Fatal("lookup returned before the slow manifest was released")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(bf.release)
+	if !<-result {
+		t.Fatal("expected Packages to be found")
+	}
+
+	// A missing file is not found after the build is finished
+	if _, ok, err := fm.lookup("dists/unstable/InRelease", acceptAny); ok || err != nil {
+		t.Fatalf("expected not found, got %v, %v", ok, err)
+	}
+	if err := fm.wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileMapDuplicateTitles(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	empty := f.add(MediaTypeEmptyJSON, []byte("{}"))
+	addManifest := func(title, content string) ocispec.Descriptor {
+		layer := f.add(MediaTypeApplicationOctetStream, []byte(content))
+		layer.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+		return f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    empty,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+	}
+	addIndex := func(manifests ...ocispec.Descriptor) ocispec.Descriptor {
+		return f.addJSON(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: manifests,
+		})
+	}
+	hello1 := addManifest("pool/main/h/hello/hello.deb", "hello 1.0")
+	hello1Again := addManifest("./pool/main/h/hello/hello.deb", "hello 1.0")
+	hello2 := addManifest("pool/main/h/hello/hello.deb", "hello 2.0")
+
+	acceptAny := func(ocispec.Descriptor) bool { return true }
+
+	// The same contents are fine
+	if _, err := buildFileMap(ctx, f, addIndex(hello1, hello1Again), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest at the lower position takes precedence, even when it is parsed later
+	for _, tc := range []struct {
+		name string
+		root ocispec.Descriptor
+	}{
+		{name: "flat", root: addIndex(hello2, hello1)},
+		{name: "nested", root: addIndex(addIndex(hello2), hello1)},
+	} {
+		bf := &blockingFetcher{memFetcher: f, blocked: hello2.Digest, release: make(chan struct{})}
+		fm := startFileMap(ctx, bf, tc.root, nil)
+		result := make(chan digest.Digest)
+		go func() {
+			desc, _, _ := fm.lookup("pool/main/h/hello/hello.deb", acceptAny)
+			result <- desc.Digest
+		}()
+		select {
+		case <-result:
+			t.Fatalf("%s: lookup returned before the manifest at the lower position was parsed", tc.name)
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(bf.release)
+		if got := <-result; got != digest.FromString("hello 2.0") {
+			t.Fatalf("%s: expected the file of hello 2.0, got %s", tc.name, got)
+		}
+		if err := fm.wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package method
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// An artifact that has only .deb files is served as a "flat" repository,
+// e.g., "deb [trusted=yes] oci://ghcr.io/foo/bar:latest ./".
+//
+// The "Packages" and "Release" files are generated in memory from the control files of the .deb files.
+// "InRelease" is generated too, when ConfigFlatRepoSigningKey is configured.
+// The .deb files are read through to verify their digests, and to compute their sizes and digests
+// for the compressed layers.
+
+const (
+	// ConfigFlatRepoSigningKey is the GnuPG key (passed to `gpg --local-user`) to sign "InRelease" of the flat repositories.
+	// "InRelease" is not generated by default, so the flat repositories have to be marked with "[trusted=yes]".
+	ConfigFlatRepoSigningKey = "Acquire::oci::FlatRepo::SigningKey"
+
+	// ConfigFlatRepoGPGHome is the GnuPG home directory (passed to `gpg --homedir`) for ConfigFlatRepoSigningKey.
+	ConfigFlatRepoGPGHome = "Acquire::oci::FlatRepo::GPGHome"
+)
+
+// arMagic is the magic string of the ar archives (.deb).
+const arMagic = "!<arch>\n"
+
+// arHeaderSize is the size of the header of an ar archive member.
+const arHeaderSize = 60
+
+// maxDebControlSize is the maximum size of the control archive of a .deb file.
+const maxDebControlSize = 64 << 20 // 64 MiB
+
+// isFlatRepo returns true if the file map has only .deb files.
+func isFlatRepo(fm *fileMap) bool {
+	if len(fm.files) == 0 {
+		return false
+	}
+	for title := range fm.files {
+		if !strings.HasSuffix(title, ".deb") {
+			return false
+		}
+	}
+	return true
+}
+
+// flatRepoFiles are the files generated for the flat repositories.
+var flatRepoFiles = []string{"InRelease", "Release", "Packages"}
+
+// generateFlatRepo adds "Packages", "Release", and optionally "InRelease" to the file map of c,
+// if c is a flat repository and title is one of them.
+// The files are generated only when they are requested, so that the .deb files are not read
+// for `apt-get install`.
+func (m *Method) generateFlatRepo(ctx context.Context, uri string, c *cacheByOCIRef, title string) error {
+	if !slices.Contains(flatRepoFiles, title) {
+		return nil
+	}
+	if err := c.wait(); err != nil {
+		return err
+	}
+	if !isFlatRepo(c.fileMap) {
+		return nil
+	}
+	var mi *mirror
+	for _, x := range c.mirrors {
+		if x.fetcher != nil && x.rootDesc.Digest == c.rootDesc.Digest {
+			mi = x
+			break
+		}
+	}
+	if mi == nil {
+		return errors.New("no mirror is available")
+	}
+	m.Statusf(uri, "Generating the flat repository from %d .deb files", len(c.files))
+	titles := slices.Sorted(maps.Keys(c.files))
+	var packages bytes.Buffer
+	for _, title := range titles {
+		desc := c.files[title]
+		control, size, dgst, err := m.readDebControl(ctx, uri, c, desc)
+		if err != nil {
+			return fmt.Errorf("failed to read the control file of %q: %w", title, err)
+		}
+		packages.Write(bytes.TrimRight(control, "\n"))
+		fmt.Fprintf(&packages, "\nFilename: %s\nSize: %d\nSHA256: %s\n\n", title, size, dgst.Encoded())
+	}
+
+	packagesDesc := c.addGenerated("Packages", packages.Bytes())
+	var release bytes.Buffer
+	annotations, err := rootAnnotations(ctx, mi)
+	if err != nil {
+		return err
+	}
+	if created, err := time.Parse(time.RFC3339, annotations[ocispec.AnnotationCreated]); err == nil {
+		fmt.Fprintf(&release, "Date: %s\n", created.UTC().Format(time.RFC1123))
+	}
+	// "Architectures" is omitted, as the flat repositories have no per-architecture indices
+	fmt.Fprintf(&release, "SHA256:\n %s %d Packages\n", packagesDesc.Digest.Encoded(), packagesDesc.Size)
+	c.addGenerated("Release", release.Bytes())
+
+	if key := m.config.Get(ConfigFlatRepoSigningKey); key != "" {
+		inRelease, err := clearsign(ctx, release.Bytes(), key, m.config.Get(ConfigFlatRepoGPGHome))
+		if err != nil {
+			return fmt.Errorf("failed to sign InRelease with %q: %w", key, err)
+		}
+		c.addGenerated("InRelease", inRelease)
+	}
+	return nil
+}
+
+// addGenerated adds the generated file to the file map.
+func (fm *fileMap) addGenerated(title string, b []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: MediaTypeApplicationOctetStream,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle: title,
+		},
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fm.generated == nil {
+		fm.generated = make(map[digest.Digest][]byte)
+	}
+	fm.generated[desc.Digest] = b
+	fm.files[title] = desc
+	return desc
+}
+
+// readDebControl reads the control file of the .deb file desc.
+// The size and the digest of the .deb file are returned too,
+// as they differ from desc for the compressed layers.
+//
+// For the uncompressed layers, only the head of the .deb file is read up to the control archive,
+// as the size and the digest are known from desc, and apt verifies the .deb file on download.
+// The compressed layers are read to the end to compute the size and the digest of the .deb file.
+func (m *Method) readDebControl(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor) (control []byte, size int64, dgst digest.Digest, _ error) {
+	r, _, err := m.fetch(ctx, uri, c, desc)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer r.Close()
+	compression, compressed := rawLayerCompression(desc)
+	if !compressed && desc.Digest.Algorithm() == digest.SHA256 {
+		control, err := debControl(r)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return control, desc.Size, desc.Digest, nil
+	}
+	verifier := desc.Digest.Verifier()
+	var deb io.Reader = io.TeeReader(r, verifier)
+	if compressed {
+		dr, err := decompress(deb, compression)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		defer dr.Close()
+		deb = dr
+	}
+	digester := digest.SHA256.Digester()
+	cr := &countingReader{r: io.TeeReader(deb, digester.Hash())}
+	control, err = debControl(cr)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	// Read the rest of the .deb file to compute its digest
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return nil, 0, "", err
+	}
+	if compressed {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, 0, "", err
+		}
+	}
+	if !verifier.Verified() {
+		return nil, 0, "", fmt.Errorf("digest mismatch of %s", desc.Digest)
+	}
+	return control, cr.n, digester.Digest(), nil
+}
+
+// debControl returns the control file in the control archive of the .deb file r.
+func debControl(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != arMagic {
+		return nil, errors.New("not an ar archive")
+	}
+	hdr := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return nil, fmt.Errorf("control archive not found: %w", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of ar member %q", name)
+		}
+		member := io.LimitReader(br, size)
+		if ext, ok := strings.CutPrefix(name, "control.tar"); ok {
+			if size > maxDebControlSize {
+				return nil, fmt.Errorf("too large control archive (%d bytes)", size)
+			}
+			compression, ok := debControlCompressions[ext]
+			if !ok {
+				return nil, fmt.Errorf("unsupported control archive %q", name)
+			}
+			return readControlTar(member, compression)
+		}
+		// The members are padded to an even size
+		if _, err := io.CopyN(io.Discard, br, size+size%2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// debControlCompressions maps the extensions of the control archives to the compressions.
+var debControlCompressions = map[string]string{
+	"":     compressionNone,
+	".gz":  compressionGzip,
+	".xz":  compressionXz,
+	".zst": compressionZstd,
+}
+
+// readControlTar reads "./control" in the control archive.
+func readControlTar(r io.Reader, compression string) ([]byte, error) {
+	dr, err := decompress(r, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("control file not found: %w", err)
+		}
+		if cleanTarPath(hdr.Name) == "control" && hdr.Typeflag == tar.TypeReg {
+			return io.ReadAll(io.LimitReader(tr, maxDebControlSize))
+		}
+	}
+}
+
+// clearsign signs b with `gpg --clearsign`.
+func clearsign(ctx context.Context, b []byte, key, home string) ([]byte, error) {
+	args := []string{"--batch", "--yes", "--armor", "--digest-algo", "SHA512", "--local-user", key, "--clearsign"}
+	if home != "" {
+		args = append([]string{"--homedir", home}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "gpg", args...)
+	cmd.Stdin = bytes.NewReader(b)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
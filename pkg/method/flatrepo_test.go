@@ -0,0 +1,175 @@
+package method
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testDeb returns a .deb file with the control file.
+func testDeb(t testing.TB, control string) []byte {
+	var ar bytes.Buffer
+	ar.WriteString(arMagic)
+	addMember := func(name string, b []byte) {
+		fmt.Fprintf(&ar, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "100644", len(b))
+		ar.Write(b)
+		if len(b)%2 == 1 {
+			ar.WriteByte('\n')
+		}
+	}
+	addMember("debian-binary", []byte("2.0\n"))
+	addMember("control.tar.gz", gzipTar(t, []tarTestEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "./md5sums", typeflag: tar.TypeReg, content: "\n"},
+		{name: "./control", typeflag: tar.TypeReg, content: control},
+	}))
+	addMember("data.tar.gz", gzipTar(t, []tarTestEntry{
+		{name: "./usr/bin/hello", typeflag: tar.TypeReg, content: "hello"},
+	}))
+	return ar.Bytes()
+}
+
+func TestGenerateFlatRepo(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	hello := testDeb(t, "Package: hello\nVersion: 1.0\nArchitecture: amd64\nDescription: hello\n world\n")
+	helloLayer := f.add(MediaTypeApplicationOctetStream, hello)
+	helloLayer.Annotations = map[string]string{ocispec.AnnotationTitle: "hello_1.0_amd64.deb"}
+
+	// A compressed layer
+	foo := testDeb(t, "Package: foo\nVersion: 2.0\nArchitecture: all\n")
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fooLayer := f.add(MediaTypeApplicationOctetStream+"+zstd", zw.EncodeAll(foo, nil))
+	fooLayer.Annotations = map[string]string{ocispec.AnnotationTitle: "foo_2.0_all.deb"}
+
+	config := f.add("application/vnd.oci.empty.v1+json", []byte("{}"))
+	root := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{helloLayer, fooLayer},
+		Annotations: map[string]string{
+			ocispec.AnnotationCreated: "2026-01-02T03:04:05Z",
+		},
+	})
+
+	fm, err := buildFileMap(ctx, f, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isFlatRepo(fm) {
+		t.Fatal("expected a flat repository")
+	}
+	mi := &mirror{fetcher: f, rootDesc: root}
+	c := &cacheByOCIRef{
+		mirrors:  []*mirror{mi},
+		rootDesc: root,
+		fileMap:  fm,
+	}
+	m := New(io.Discard, nil)
+	const uri = "oci://example.com/foo:latest/./Release"
+	// The files are not generated for the .deb files
+	if err := m.generateFlatRepo(ctx, uri, c, "hello_1.0_amd64.deb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.files["Packages"]; ok {
+		t.Fatal("Packages should not be generated for a .deb file")
+	}
+	if err := m.generateFlatRepo(ctx, uri, c, "Release"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.files["InRelease"]; ok {
+		t.Fatal("InRelease should not be generated without the signing key")
+	}
+
+	read := func(title string) string {
+		r, _, err := m.fetch(ctx, uri, c, c.files[title])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	expectedPackages := "Package: foo\nVersion: 2.0\nArchitecture: all\n" +
+		"Filename: foo_2.0_all.deb\n" +
+		fmt.Sprintf("Size: %d\nSHA256: %s\n\n", len(foo), digest.FromBytes(foo).Encoded()) +
+		"Package: hello\nVersion: 1.0\nArchitecture: amd64\nDescription: hello\n world\n" +
+		"Filename: hello_1.0_amd64.deb\n" +
+		fmt.Sprintf("Size: %d\nSHA256: %s\n\n", len(hello), digest.FromBytes(hello).Encoded())
+	packages := read("Packages")
+	if packages != expectedPackages {
+		t.Fatalf("unexpected Packages:\n%s", packages)
+	}
+	release := read("Release")
+	expectedRelease := "Date: Fri, 02 Jan 2026 03:04:05 UTC\n" +
+		fmt.Sprintf("SHA256:\n %s %d Packages\n", digest.FromString(packages).Encoded(), len(packages))
+	if release != expectedRelease {
+		t.Fatalf("unexpected Release:\n%s", release)
+	}
+
+	// Not a flat repository
+	fm.files["Release"] = c.files["Release"]
+	delete(fm.files, "Packages")
+	if isFlatRepo(fm) {
+		t.Fatal("expected not a flat repository")
+	}
+}
+
+func TestDebControlTampered(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deb := testDeb(t, "Package: hello\n")
+	// The compressed layers are read to the end, and verified
+	layer := f.add(MediaTypeApplicationOctetStream+"+zstd", zw.EncodeAll(deb, nil))
+	layer.Annotations = map[string]string{ocispec.AnnotationTitle: "hello.deb"}
+	f[layer.Digest] = zw.EncodeAll(bytes.Replace(deb, []byte("2.0\n"), []byte("2.1\n"), 1), nil)
+	c := &cacheByOCIRef{
+		mirrors: []*mirror{{fetcher: f}},
+		fileMap: newFileMap(),
+	}
+	m := New(io.Discard, nil)
+	_, _, _, err = m.readDebControl(ctx, "oci://example.com/foo:latest/./Release", c, layer)
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+}
+
+func TestDebControlHead(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	deb := testDeb(t, "Package: hello\n")
+	layer := f.add(MediaTypeApplicationOctetStream, deb)
+	layer.Annotations = map[string]string{ocispec.AnnotationTitle: "hello.deb"}
+	// The uncompressed layers are not read after the control archive
+	f[layer.Digest] = deb[:bytes.Index(deb, []byte("data.tar.gz"))]
+	c := &cacheByOCIRef{
+		mirrors: []*mirror{{fetcher: f}},
+		fileMap: newFileMap(),
+	}
+	m := New(io.Discard, nil)
+	control, size, dgst, err := m.readDebControl(ctx, "oci://example.com/foo:latest/./Release", c, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(control) != "Package: hello\n" || size != layer.Size || dgst != layer.Digest {
+		t.Fatalf("unexpected control %q, size %d, digest %s", control, size, dgst)
+	}
+}
@@ -0,0 +1,93 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ledger"
+	refdocker "github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// ConfigLedgerDir is the directory of the trust-on-first-use ledger of the root digests.
+	// The ledger is disabled when the directory does not exist.
+	ConfigLedgerDir = "Acquire::oci::Ledger::Dir"
+
+	// ConfigLedgerMode is "warn" (default), "fail", or "off".
+	ConfigLedgerMode = "Acquire::oci::Ledger::Mode"
+
+	// FailReasonRollback is the FailReason for the rollbacks detected with the ledger.
+	FailReasonRollback = "Rollback"
+)
+
+// Values of ConfigLedgerMode.
+const (
+	ledgerModeWarn = "warn"
+	ledgerModeFail = "fail"
+	ledgerModeOff  = "off"
+)
+
+// checkLedger checks mi.rootDesc against the ledger, and records it.
+// mi has to be resolved with resolveMirror.
+func (m *Method) checkLedger(ctx context.Context, uri string, mi *mirror) error {
+	mode := m.config.GetDefault(ConfigLedgerMode, ledgerModeWarn)
+	switch mode {
+	case ledgerModeOff:
+		return nil
+	case ledgerModeWarn, ledgerModeFail:
+	default:
+		return fmt.Errorf("unknown %s %q", ConfigLedgerMode, mode)
+	}
+	if _, ok := mi.ref.(refdocker.Digested); ok {
+		// Immutable
+		return nil
+	}
+	dir := m.config.GetDefault(ConfigLedgerDir, ledger.DefaultDir)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		m.Statusf(uri, "Ledger directory %q does not exist, skipping the rollback check", dir)
+		return nil
+	}
+
+	created, err := rootCreated(ctx, mi)
+	if err == nil {
+		m.Statusf(uri, "Checking the ledger for %q (digest=%s, created=%v)", mi.ref, mi.rootDesc.Digest, created)
+		err = ledger.New(dir).Check(mi.ref.String(), mi.rootDesc.Digest, created)
+	}
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ledger.ErrRollback) {
+		if mode == ledgerModeFail {
+			return &failReasonError{reason: FailReasonRollback, err: err}
+		}
+		m.w.Warningf("%v", err)
+		return nil
+	}
+	if mode == ledgerModeFail {
+		return fmt.Errorf("failed to check the ledger: %w", err)
+	}
+	m.w.Warningf("Failed to check the ledger: %v", err)
+	return nil
+}
+
+// rootCreated returns the "org.opencontainers.image.created" annotation of mi.rootDesc.
+// The zero time is returned when the annotation is missing.
+func rootCreated(ctx context.Context, mi *mirror) (time.Time, error) {
+	annotations, err := rootAnnotations(ctx, mi)
+	if err != nil {
+		return time.Time{}, err
+	}
+	s := annotations[ocispec.AnnotationCreated]
+	if s == "" {
+		return time.Time{}, nil
+	}
+	created, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse %s %q: %w", ocispec.AnnotationCreated, s, err)
+	}
+	return created, nil
+}
@@ -7,16 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"path"
 	"strconv"
 	"strings"
 
 	"github.com/AkihiroSuda/apt-transport-oci/pkg/apt"
 	"github.com/AkihiroSuda/apt-transport-oci/pkg/dockerconfigresolver"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/signature"
 	"github.com/AkihiroSuda/apt-transport-oci/pkg/version"
-	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/containerd/v2/core/remotes"
 	refdocker "github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
@@ -26,13 +25,24 @@ import (
 // Protocol: see https://justi.cz/security/2019/01/22/apt-rce.html
 // See also the output of `apt-get -o Debug::pkgAcquire::Worker=1 update`
 const (
-	CodeURIAcquire     = 600
-	FieldURI           = "URI"
-	FieldMessage       = "Message"
-	FieldTargetRepoURI = "Target-Repo-URI"
-	FieldFilename      = "Filename"
-	FieldSize          = "Size"
-	FieldSHA256Hash    = "SHA256-Hash"
+	CodeURIAcquire      = 600
+	CodeConfiguration   = 601
+	FieldURI            = "URI"
+	FieldMessage        = "Message"
+	FieldTargetRepoURI  = "Target-Repo-URI"
+	FieldFilename       = "Filename"
+	FieldSize           = "Size"
+	FieldSHA256Hash     = "SHA256-Hash"
+	FieldConfigItem     = "Config-Item"
+	FieldExpectedSHA256 = "Expected-SHA256"
+)
+
+// URI schemes
+const (
+	SchemeOCI = "oci://"
+	// SchemeOCIPlainHTTP is for insecure registries without TLS.
+	// The host has to be allowed in ConfigAllowPlainHTTP.
+	SchemeOCIPlainHTTP = "oci+http://"
 )
 
 const (
@@ -42,16 +52,25 @@ const (
 
 func New(out io.Writer, in io.Reader) *Method {
 	m := &Method{
-		w:             apt.NewMessageWriter(out),
-		r:             apt.NewMessageReader(bufio.NewReader(in)),
-		cacheByOCIRef: make(map[string]cacheByOCIRef),
+		w:                        apt.NewMessageWriter(out),
+		r:                        apt.NewMessageReader(bufio.NewReader(in)),
+		cacheByOCIRef:            make(map[string]*cacheByOCIRef),
+		seenSuites:               make(map[string][]string),
+		sourceRepositoryFetchers: make(map[string]remotes.Fetcher),
+		resolvers:                dockerconfigresolver.NewCache(),
+		config:                   make(config),
 	}
 	return m
 }
 
 type cacheByOCIRef struct {
-	fetcher remotes.Fetcher
-	fileMap map[string]ocispec.Descriptor
+	// mirrors are sorted by the priority
+	mirrors  []*mirror
+	rootDesc ocispec.Descriptor
+	*fileMap
+	// overlays maps the digests of the files served from the overlays to the overlays.
+	// See overlay.go.
+	overlays map[digest.Digest]*mirror
 }
 
 type Method struct {
@@ -59,7 +78,31 @@ type Method struct {
 	r *apt.MessageReader
 
 	// no need to consider cache invalidation, as the process lifecycle is short
-	cacheByOCIRef map[string]cacheByOCIRef
+	cacheByOCIRef map[string]*cacheByOCIRef
+
+	config config
+
+	// verifiers is initialized on the first call of signatureVerifiers
+	verifiers []signature.Verifier
+
+	// seenSuites maps the repoURI of tagless sources to the suites requested in this process
+	seenSuites map[string][]string
+
+	// sourceRepositoryFetchers maps the names of the source repositories to the fetchers.
+	// See crossrepo.go.
+	sourceRepositoryFetchers map[string]remotes.Fetcher
+
+	// resolvers shares the resolvers, the bearer tokens, and the connections
+	// across the refs on the same registry host.
+	resolvers *dockerconfigresolver.Cache
+
+	// spool is initialized on the first call of tarSpool
+	spool       *spool
+	spoolFailed bool
+
+	// pol is loaded on the first call of policy; nil if there is no policy file
+	pol          *policy
+	policyLoaded bool
 
 	// TODO: add multi-threading with mutex to support CapPipeLine
 }
@@ -68,7 +111,7 @@ type Method struct {
 func (m *Method) Run(ctx context.Context) {
 	version := fmt.Sprintf("%d.%d", version.Major, version.Minor)
 	// TODO: enable apt.CapPipeline
-	var caps apt.CapFlags
+	caps := apt.CapSendConfig
 	m.w.Capabilities(version, caps)
 	for {
 		msg, err := m.r.ReadMessage()
@@ -86,6 +129,8 @@ func (m *Method) Run(ctx context.Context) {
 		switch msg.StatusCode {
 		case CodeURIAcquire:
 			m.handleURIAcquire(ctx, msg)
+		case CodeConfiguration:
+			m.handleConfiguration(msg)
 		default:
 			m.w.Logf("Unknown message: %d %s", msg.StatusCode, msg.Description)
 		}
@@ -93,25 +138,49 @@ func (m *Method) Run(ctx context.Context) {
 }
 
 func (m *Method) handleURIAcquire(ctx context.Context, msg *apt.Message) {
-	if started, err := m.acquire(ctx, msg); err != nil {
-		const (
-			transientError = false
-			usedMirror     = false
-		)
+	if started, usedMirror, err := m.acquire(ctx, msg); err != nil {
+		transientError := isTransientError(err)
 		uri := msg.Fields[FieldURI]
 		if !started {
 			m.w.StartURI(uri, "", 0, usedMirror)
 		}
-		m.w.FailedURI(uri, err.Error(), err.Error(), transientError, usedMirror)
+		m.w.FailedURI(uri, err.Error(), failReason(err), transientError, usedMirror)
+	}
+}
+
+// failReasonError is an error with the "FailReason" field of "400 URI Failure".
+type failReasonError struct {
+	reason string
+	err    error
+}
+
+func (e *failReasonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *failReasonError) Unwrap() error {
+	return e.err
+}
+
+// failReason returns the "FailReason" field for err, or "" if err has no reason code.
+func failReason(err error) string {
+	var fre *failReasonError
+	if errors.As(err, &fre) {
+		return fre.reason
 	}
+	return ""
 }
 
-func (m *Method) ociResolver(named refdocker.Named) (remotes.Resolver, error) {
+func (m *Method) ociResolver(named refdocker.Named, plainHTTP bool) (remotes.Resolver, error) {
 	ref := named.String()
 	refDomain := refdocker.Domain(named)
-	var dOpts []dockerconfigresolver.Opt
-	// TODO: support insecure non-TLS registry
-	resolver, err := dockerconfigresolver.New(refDomain, dOpts...)
+	dOpts := []dockerconfigresolver.Opt{
+		dockerconfigresolver.WithPlainHTTP(plainHTTP),
+		dockerconfigresolver.WithRepository(refdocker.Path(named)),
+		dockerconfigresolver.WithHostsDir(m.config.GetDefault(ConfigHostsDir, DefaultHostsDir)),
+		dockerconfigresolver.WithProxy(m.config.proxyFunc()),
+	}
+	resolver, err := m.resolvers.Resolver(refDomain, dOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a resolver for refDomain=%q (ref=%q): %w", refDomain, ref, err)
 	}
@@ -132,63 +201,56 @@ func (m *Method) ociFetcher(ctx context.Context, named refdocker.Named, resolver
 	return fetcher, rootDesc, nil
 }
 
-func buildFileMap(ctx context.Context, fetcher remotes.Fetcher, rootDesc ocispec.Descriptor) (map[string]ocispec.Descriptor, error) {
-	files := make(map[string]ocispec.Descriptor)
-	handler := images.HandlerFunc(
-		func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
-			switch desc.MediaType {
-			case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
-				r, err := fetcher.Fetch(ctx, desc)
-				if err != nil {
-					return nil, err
-				}
-				defer r.Close()
-				b, err := ioutil.ReadAll(r)
-				if err != nil {
-					return nil, err
-				}
-				var manifest ocispec.Manifest
-				if err := json.Unmarshal(b, &manifest); err != nil {
-					return nil, err
-				}
-				for _, l := range manifest.Layers {
-					if title := l.Annotations[ocispec.AnnotationTitle]; title != "" {
-						cleanPath := path.Clean(title)
-						files[cleanPath] = l
-					}
-				}
-			case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
-				r, err := fetcher.Fetch(ctx, desc)
-				if err != nil {
-					return nil, err
-				}
-				defer r.Close()
-				b, err := ioutil.ReadAll(r)
-				if err != nil {
-					return nil, err
-				}
-				var index ocispec.Index
-				if err := json.Unmarshal(b, &index); err != nil {
-					return nil, err
-				}
-				return index.Manifests, nil
-			}
-			return nil, nil
-		})
-	if err := images.Dispatch(ctx, handler, nil, rootDesc); err != nil {
+// rootAnnotations returns the annotations of mi.rootDesc.
+// mi has to be resolved with resolveMirror.
+func rootAnnotations(ctx context.Context, mi *mirror) (map[string]string, error) {
+	if mi.annotations != nil {
+		return mi.annotations, nil
+	}
+	b, err := ociutil.ReadBlob(ctx, mi.fetcher, mi.rootDesc)
+	if err != nil {
 		return nil, err
 	}
-	return files, nil
+	// Both manifests and indexes have the annotations
+	var root struct {
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	mi.annotations = root.Annotations
+	if mi.annotations == nil {
+		mi.annotations = make(map[string]string)
+	}
+	return mi.annotations, nil
 }
 
 func parseURI(uri string) (repo, path string, _ error) {
 	// The format here would be something like: registry.somehost.com/some/repo:tag/SomeFile
 
-	trimmed := strings.TrimPrefix(uri, "oci://")
+	scheme := SchemeOCI
+	if strings.HasPrefix(uri, SchemeOCIPlainHTTP) {
+		scheme = SchemeOCIPlainHTTP
+	}
+	trimmed := strings.TrimPrefix(uri, scheme)
 	if trimmed == uri {
 		return "", "", fmt.Errorf("missing oci:// protocol in uri")
 	}
 
+	// The repo may be tagless: registry.somehost.com/some/repo/dists/stable/SomeFile
+	// The tag is chosen from the suite. See suite.go.
+	if i := taglessRepoEnd(trimmed); i > 0 {
+		return scheme + trimmed[:i+1], trimmed[i+1:], nil
+	}
+
+	// The registry host may have a port number: registry.somehost.com:5000/some/repo:tag/SomeFile
+	// The port is numeric, so that "foo.bar:latest/dists/x:y" is split at the tag.
+	var host string
+	if hostPart, rest, ok := strings.Cut(trimmed, "/"); ok && hasNumericPort(hostPart) && strings.Contains(rest, ":") {
+		host = hostPart + "/"
+		trimmed = rest
+	}
+
 	split := strings.SplitN(trimmed, ":", 2)
 	if len(split) < 2 {
 		return "", "", fmt.Errorf("uri is missing repo tag")
@@ -197,7 +259,7 @@ func parseURI(uri string) (repo, path string, _ error) {
 	// Combine everything up to but not including the tag
 	// We don't quite have the tag yet because it (should) have a file path added
 	// to the end that we need to split off first.
-	repo = "oci://" + split[0] + ":"
+	repo = scheme + host + split[0] + ":"
 	tagAndFile := strings.SplitN(split[1], "/", 2)
 
 	// We add "/" here, otherwise we'll end up with an extra preceding "/" on the
@@ -211,31 +273,130 @@ func parseURI(uri string) (repo, path string, _ error) {
 	return repo, path, nil
 }
 
-func parseURIFields(msg *apt.Message) (ociRef refdocker.Named, title string, err error) {
+// hasNumericPort returns true if hostPart is like "registry.somehost.com:5000".
+func hasNumericPort(hostPart string) bool {
+	_, port, ok := strings.Cut(hostPart, ":")
+	if !ok || port == "" {
+		return false
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// taglessRepoEnd returns the index of "/dists/" or "/pool/" that follows a tagless repo, or -1.
+// The repo is tagged when the path after the host has ":tag" or "@digest",
+// even if a path component of the repo is named "dists" or "pool".
+// The file paths of apt repositories do not have ':' nor '@'.
+func taglessRepoEnd(s string) int {
+	host, rest, ok := strings.Cut(s, "/")
+	if !ok || strings.ContainsAny(rest, ":@") {
+		return -1
+	}
+	i := -1
+	for _, sep := range []string{"/dists/", "/pool/"} {
+		if j := strings.Index("/"+rest, sep); j > 0 && (i < 0 || j < i) {
+			i = j
+		}
+	}
+	if i < 0 {
+		return -1
+	}
+	return len(host) + i
+}
+
+// source is an apt source, such as "oci://ghcr.io/foo/bar:latest/".
+type source struct {
+	// repoURI is the Target-Repo-URI, such as "oci://ghcr.io/foo/bar:latest/"
+	repoURI string
+	// mirrors has multiple elements when the source is a mirror list ("oci+mirror+file:").
+	mirrors []*mirror
+}
+
+// String returns the references of the source.
+func (src *source) String() string {
+	refs := make([]string, len(src.mirrors))
+	for i, mi := range src.mirrors {
+		refs[i] = mi.ref.String()
+	}
+	return strings.Join(refs, ",")
+}
+
+// parseURIFields parses the URI fields, and checks the references against pol.
+// pol may be nil.
+func parseURIFields(msg *apt.Message, pol *policy) (src *source, title string, err error) {
+	uri := msg.Fields[FieldURI]
 	repoURI := msg.Fields[FieldTargetRepoURI]
+	if strings.HasPrefix(uri, mirrorListPrefix) {
+		src, title, err = parseMirrorURIFields(uri, repoURI)
+	} else {
+		src, title, err = parseOCIURIFields(uri, repoURI)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	for _, mi := range src.mirrors {
+		if err := pol.check(mi.ref); err != nil {
+			return nil, "", err
+		}
+	}
+	return src, title, nil
+}
+
+func parseOCIURIFields(uri, repoURI string) (src *source, title string, err error) {
 	if repoURI == "" {
-		uri := msg.Fields[FieldURI]
 		if uri == "" {
-			return ociRef, "", fmt.Errorf("missing field %q", FieldTargetRepoURI)
+			return nil, "", fmt.Errorf("missing field %q", FieldTargetRepoURI)
 		}
 		repoURI, _, err = parseURI(uri)
 		if err != nil {
-			return ociRef, "", err
+			return nil, "", err
 		}
 	}
-	if !strings.HasPrefix(repoURI, "oci://") {
-		return ociRef, "", fmt.Errorf("field %s lacks \"oci://\" prefix: %q", FieldTargetRepoURI, repoURI)
+	ociRef, plainHTTP, err := parseRepoURI(repoURI)
+	if err != nil {
+		return nil, "", err
+	}
+	src = &source{
+		repoURI: repoURI,
+		mirrors: []*mirror{{ref: ociRef, plainHTTP: plainHTTP}},
+	}
+	return src, trimTitle(uri, repoURI), nil
+}
+
+// parseRepoURI parses a repo URI such as "oci://ghcr.io/foo/bar:latest/".
+// plainHTTP is true for "oci+http://".
+func parseRepoURI(repoURI string) (ociRef refdocker.Named, plainHTTP bool, err error) {
+	var refTmp string
+	switch {
+	case strings.HasPrefix(repoURI, SchemeOCI):
+		refTmp = strings.TrimPrefix(repoURI, SchemeOCI)
+	case strings.HasPrefix(repoURI, SchemeOCIPlainHTTP):
+		refTmp = strings.TrimPrefix(repoURI, SchemeOCIPlainHTTP)
+		plainHTTP = true
+	default:
+		return nil, false, fmt.Errorf("field %s lacks \"oci://\" prefix: %q", FieldTargetRepoURI, repoURI)
 	}
-	refTmp := strings.TrimPrefix(repoURI, "oci://")
 	refTmp = strings.TrimSuffix(refTmp, "/")
-	ociRef, err = refdocker.ParseDockerRef(refTmp)
+	ociRef, err = refdocker.ParseNormalizedNamed(refTmp)
+	if err == nil && !refdocker.IsNameOnly(ociRef) {
+		ociRef, err = refdocker.ParseDockerRef(refTmp)
+	}
 	if err != nil {
-		return ociRef, "", fmt.Errorf("failed to parse %q (%s=%q) as Docker reference: %w", refTmp, FieldTargetRepoURI, repoURI, err)
+		return nil, false, fmt.Errorf("failed to parse %q (%s=%q) as Docker reference: %w", refTmp, FieldTargetRepoURI, repoURI, err)
 	}
-	title = strings.TrimPrefix(msg.Fields[FieldURI], repoURI)
+	// ociRef is name-only for a tagless repo
+	return ociRef, plainHTTP, nil
+}
+
+func trimTitle(uri, repoURI string) string {
+	title := strings.TrimPrefix(uri, repoURI)
 	// not robust, but no security issue (cuz not referring to the actual filesystem)
 	title = strings.TrimPrefix(title, "./")
-	return ociRef, title, nil
+	return title
 }
 
 func (m *Method) Status(uri, s string) {
@@ -254,81 +415,165 @@ func (m *Method) Statusf(uri, fmtspec string, args ...interface{}) {
 	m.Status(uri, fmt.Sprintf(fmtspec, args...))
 }
 
-func (m *Method) doCacheStuff(ctx context.Context, uri string, ociRef refdocker.Named) (*cacheByOCIRef, error) {
-	if x, ok := m.cacheByOCIRef[ociRef.String()]; ok {
-		return &x, nil
+// doCacheStuff returns the cache of src, resolving the mirrors of src in the priority order.
+// On errors, the mirror that has failed is returned too, unless the error is not specific to a mirror.
+func (m *Method) doCacheStuff(ctx context.Context, uri string, src *source) (*cacheByOCIRef, *mirror, error) {
+	if x, ok := m.cacheByOCIRef[src.String()]; ok {
+		return x, nil, nil
 	}
 
-	m.Statusf(uri, "Creating a resolver for ociRef=%q", ociRef)
-	resolver, err := m.ociResolver(ociRef)
+	pol, err := m.policy()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	m.Statusf(uri, "Creating a fetcher for ociRef=%q", ociRef)
-	fetcher, rootDesc, err := m.ociFetcher(ctx, ociRef, resolver)
+	verifiers, err := m.signatureVerifiers()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	m.Statusf(uri, "Building file map for rootDesc=%+v", rootDesc)
-	fileMap, err := buildFileMap(ctx, fetcher, rootDesc)
-	if err != nil {
-		return nil, err
+	verifierNames := make([]string, len(verifiers))
+	for i, v := range verifiers {
+		verifierNames[i] = v.Name()
+	}
+	for _, mi := range src.mirrors {
+		if err := pol.check(mi.ref); err != nil {
+			return nil, mi, err
+		}
+		if err := pol.checkSignature(mi.ref, verifierNames); err != nil {
+			return nil, mi, err
+		}
 	}
 
-	c := cacheByOCIRef{
-		fetcher: fetcher,
-		fileMap: fileMap,
+	c := &cacheByOCIRef{
+		mirrors: src.mirrors,
+	}
+	// All the mirrors have to serve the root digest of the first mirror that is resolved,
+	// even when that mirror fails later (e.g., in the signature verification),
+	// so that an out-of-sync mirror cannot serve another root in the same run.
+	var rootDigest digest.Digest
+	for i, mi := range c.mirrors {
+		err := m.resolveMirror(ctx, uri, mi, rootDigest)
+		if err == nil {
+			if rootDigest == "" {
+				rootDigest = mi.rootDesc.Digest
+			}
+			err = m.verifySignatures(ctx, uri, mi)
+		}
+		if err == nil {
+			err = m.checkLedger(ctx, uri, mi)
+		}
+		if err == nil {
+			c.fileMap, c.overlays, err = m.buildOverlayFileMap(ctx, uri, mi, pol, verifierNames)
+		}
+		if err == nil {
+			c.rootDesc = mi.rootDesc
+			m.cacheByOCIRef[src.String()] = c
+			return c, nil, nil
+		}
+		mi.err = err
+		if (!isTransientError(err) && !errors.Is(err, errMirrorOutOfSync)) || i == len(c.mirrors)-1 {
+			return nil, mi, err
+		}
+		m.w.Warningf("Skipping mirror %q: %v", mi.uri, err)
 	}
-	m.cacheByOCIRef[ociRef.String()] = c
-	return &c, nil
+	return nil, nil, errors.New("no mirror is available")
 }
 
-func (m *Method) acquire(ctx context.Context, msg *apt.Message) (started bool, err error) {
+func (m *Method) acquire(ctx context.Context, msg *apt.Message) (started bool, usedMirror string, err error) {
 	uri := msg.Fields[FieldURI]
 	filename := msg.Fields[FieldFilename]
-	// TODO: support "Expected-SHA256"
 
 	m.Statusf(uri, "Parsing msg: %+v", msg)
-	ociRef, title, err := parseURIFields(msg)
+	pol, err := m.policy()
 	if err != nil {
-		return started, err
+		return started, usedMirror, err
 	}
-
-	c, err := m.doCacheStuff(ctx, uri, ociRef)
+	src, title, err := parseURIFields(msg, pol)
 	if err != nil {
-		return started, err
+		return started, usedMirror, err
 	}
 
-	desc, ok := c.fileMap[title]
-	if !ok {
-		return started, fmt.Errorf("file not found in %q: %q", ociRef, title)
+	srcs, err := m.suiteSources(src, title)
+	if err != nil {
+		return started, usedMirror, err
+	}
+	expectedSHA256 := msg.Fields[FieldExpectedSHA256]
+	c, desc, failed, err := m.lookupFile(ctx, uri, srcs, title, expectedSHA256)
+	if failed != nil {
+		usedMirror = failed.uri
+	}
+	var variant *indexVariant
+	if errors.Is(err, errFileNotFound) {
+		var variantErr error
+		c, variant, variantErr = m.lookupVariant(ctx, uri, srcs, title, expectedSHA256)
+		if variantErr != nil {
+			m.Statusf(uri, "Cannot synthesize %q: %v", title, variantErr)
+			return started, usedMirror, err
+		}
+		desc = variant.src
+		m.Statusf(uri, "Synthesizing %q from %q", title, desc.Annotations[ocispec.AnnotationTitle])
+	} else if err != nil {
+		return started, usedMirror, err
 	}
 	m.Statusf(uri, "Found descriptor for %q: %+v", title, desc)
-	switch desc.MediaType {
+	compression, compressed := rawLayerCompression(desc)
+	switch baseMediaType(desc.MediaType) {
 	case MediaTypeApplicationOctetStream, MediaTypeApplicationXBinary:
 		// NOP
 	default:
 		m.w.Warningf("expected media type of %q to be %q, got %q", title, MediaTypeApplicationXBinary, desc.MediaType)
 	}
 
-	const (
-		resumePoint = ""
-		usedMirror  = false
-	)
-	m.w.StartURI(uri, resumePoint, desc.Size, usedMirror)
-	started = true
-
-	r, err := c.fetcher.Fetch(ctx, desc)
+	r, mi, err := m.fetch(ctx, uri, c, desc)
+	if mi != nil {
+		usedMirror = mi.uri
+	}
 	if err != nil {
-		return started, err
+		return started, usedMirror, err
 	}
 	defer r.Close()
 
+	// content is the stream of r, decompressed for the compressed layers, and converted for the synthesized variants.
+	// The descriptor digest is verified over the stream r for them.
+	transformed := compressed || variant != nil
+	var (
+		content       = io.Reader(r)
+		size          = desc.Size
+		layerVerifier digest.Verifier
+	)
+	if transformed {
+		layerVerifier = desc.Digest.Verifier()
+		r = &readCloser{Reader: io.TeeReader(r, layerVerifier), close: r.Close}
+		content = r
+	}
+	if compressed {
+		m.Statusf(uri, "Decompressing %q (%s)", title, compression)
+		dr, err := decompress(r, compression)
+		if err != nil {
+			return started, usedMirror, fmt.Errorf("failed to decompress %q: %w", title, err)
+		}
+		defer dr.Close()
+		content = dr
+		// Unknown
+		size = 0
+	}
+	if variant != nil {
+		vr, err := variant.synthesize(content)
+		if err != nil {
+			return started, usedMirror, fmt.Errorf("failed to synthesize %q: %w", title, err)
+		}
+		defer vr.Close()
+		content = vr
+		// Unknown
+		size = 0
+	}
+
+	const resumePoint = ""
+	m.w.StartURI(uri, resumePoint, size, usedMirror)
+	started = true
+
 	w, err := os.Create(filename)
 	if err != nil {
-		return started, err
+		return started, usedMirror, err
 	}
 	defer w.Close()
 
@@ -336,23 +581,38 @@ func (m *Method) acquire(ctx context.Context, msg *apt.Message) (started bool, e
 	hasher := digester.Hash()
 	mw := io.MultiWriter(w, hasher)
 
-	if _, err := io.Copy(mw, r); err != nil {
+	n, err := io.Copy(mw, content)
+	if err != nil {
 		// TODO: show progress
-		return started, err
+		return started, usedMirror, err
 	}
 
 	if err := w.Close(); err != nil {
-		return started, err
+		return started, usedMirror, err
+	}
+
+	if transformed {
+		// Read the rest of the stream, such as the padding, to verify the digest
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return started, usedMirror, err
+		}
 	}
 
 	if err := r.Close(); err != nil {
-		return started, err
+		return started, usedMirror, err
 	}
 
 	dig := digester.Digest()
 
-	if desc.Digest.Algorithm() == dig.Algorithm() && desc.Digest.Encoded() != dig.Encoded() {
-		return started, fmt.Errorf("expected digest of %q to be %s, got %s", title, desc.Digest, dig)
+	if transformed {
+		if !layerVerifier.Verified() {
+			return started, usedMirror, fmt.Errorf("expected digest of the layer of %q to be %s", title, desc.Digest)
+		}
+	} else if desc.Digest.Algorithm() == dig.Algorithm() && desc.Digest.Encoded() != dig.Encoded() {
+		return started, usedMirror, fmt.Errorf("expected digest of %q to be %s, got %s", title, desc.Digest, dig)
+	}
+	if variant != nil && dig.Encoded() != expectedSHA256 {
+		return started, usedMirror, fmt.Errorf("expected SHA256 of synthesized %q to be %s, got %s", title, expectedSHA256, dig.Encoded())
 	}
 
 	const (
@@ -360,9 +620,9 @@ func (m *Method) acquire(ctx context.Context, msg *apt.Message) (started bool, e
 		imsHit    = false
 	)
 	fields := []apt.Field{
-		{Key: FieldSize, Value: strconv.Itoa(int(desc.Size))},
+		{Key: FieldSize, Value: strconv.FormatInt(n, 10)},
 		{Key: FieldSHA256Hash, Value: dig.Encoded()},
 	}
 	m.w.FinishURI(uri, filename, resumePoint, altIMSHit, imsHit, usedMirror, fields...)
-	return started, nil
+	return started, usedMirror, nil
 }
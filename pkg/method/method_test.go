@@ -55,6 +55,50 @@ func TestParseURI(t *testing.T) {
 			xRepo: "oci://foo.bar/namespace:latest/",
 			xPath: "Nested/File",
 		},
+		"with plain http proto host, namespace, tag, and nested path": {
+			v:     "oci+http://foo.bar:5000/namespace:latest/Nested/File",
+			xRepo: "oci+http://foo.bar:5000/namespace:latest/",
+			xPath: "Nested/File",
+		},
+		"with proto host, tag, and path with a colon": {
+			v:     "oci://foo.bar:latest/dists/x:y",
+			xRepo: "oci://foo.bar:latest/",
+			xPath: "dists/x:y",
+		},
+		"with proto host, port, nested namespace, tag, and path": {
+			v:     "oci://foo.bar:5000/namespace/repo:latest/File",
+			xRepo: "oci://foo.bar:5000/namespace/repo:latest/",
+			xPath: "File",
+		},
+		"tagless with dists": {
+			v:     "oci://foo.bar/namespace/dists/stable/InRelease",
+			xRepo: "oci://foo.bar/namespace/",
+			xPath: "dists/stable/InRelease",
+		},
+		"tagless with port and pool": {
+			v:     "oci://foo.bar:5000/namespace/pool/main/h/hello/hello_1.0_amd64.deb",
+			xRepo: "oci://foo.bar:5000/namespace/",
+			xPath: "pool/main/h/hello/hello_1.0_amd64.deb",
+		},
+		"with tag and dists": {
+			v:     "oci://foo.bar/namespace:latest/dists/stable/InRelease",
+			xRepo: "oci://foo.bar/namespace:latest/",
+			xPath: "dists/stable/InRelease",
+		},
+		"with tag and pool in the repo": {
+			v:     "oci://ghcr.io/org/pool/repo:latest/dists/stable/InRelease",
+			xRepo: "oci://ghcr.io/org/pool/repo:latest/",
+			xPath: "dists/stable/InRelease",
+		},
+		"with port, digest, and dists in the repo": {
+			v:     "oci://foo.bar:5000/dists/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/pool/main/h/hello/hello_1.0_amd64.deb",
+			xRepo: "oci://foo.bar:5000/dists/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/",
+			xPath: "pool/main/h/hello/hello_1.0_amd64.deb",
+		},
+		"with unknown proto host and tag": {
+			v:   "oci+ftp://foo.bar:latest/",
+			err: true,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			uri := uri
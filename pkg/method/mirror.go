@@ -0,0 +1,285 @@
+package method
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	remoteerrors "github.com/containerd/containerd/v2/core/remotes/errors"
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// mirrorListPrefix is the prefix of a source that refers to a mirror list file, like apt's "mirror+file:".
+// e.g., "oci+mirror+file:/etc/apt/apt-transport-oci/mirrors/foo.list"
+//
+// The mirror list file contains the URIs of equivalent registries, one per line:
+//
+//	oci://ghcr.io/foo/bar:latest priority:1
+//	oci://harbor.example.com/foo/bar:latest priority:2
+//
+// Lower priorities are tried first. The default priority is 0.
+const mirrorListPrefix = "oci+mirror+file:"
+
+// mirror is an entry of a source.
+// A regular "oci://" source has a single mirror.
+type mirror struct {
+	// uri is the URI in the mirror list, or "" for a regular "oci://" source.
+	// uri is reported to apt as "UsedMirror".
+	uri       string
+	ref       refdocker.Named
+	plainHTTP bool
+
+	// The following fields are set by resolveMirror
+	resolver remotes.Resolver
+	fetcher  remotes.Fetcher
+	rootDesc ocispec.Descriptor
+	// annotations is set by rootAnnotations
+	annotations map[string]string
+	// err is set when the mirror is unusable in this run
+	err error
+}
+
+// parseMirrorURIFields parses the URI fields of a "oci+mirror+file:" source.
+func parseMirrorURIFields(uri, repoURI string) (src *source, title string, err error) {
+	if repoURI == "" {
+		// Find the mirror list file in "oci+mirror+file:/path/to/list/FILE"
+		p := strings.TrimPrefix(uri, mirrorListPrefix)
+		for i := 1; i <= len(p); i++ {
+			if i != len(p) && p[i] != '/' {
+				continue
+			}
+			if st, err := os.Stat(p[:i]); err == nil && st.Mode().IsRegular() {
+				repoURI = mirrorListPrefix + p[:i] + "/"
+				break
+			}
+		}
+		if repoURI == "" {
+			return nil, "", fmt.Errorf("no mirror list file was found in uri %q", uri)
+		}
+	}
+	if !strings.HasPrefix(repoURI, mirrorListPrefix) {
+		return nil, "", fmt.Errorf("field %s lacks %q prefix: %q", FieldTargetRepoURI, mirrorListPrefix, repoURI)
+	}
+	listFile := strings.TrimSuffix(strings.TrimPrefix(repoURI, mirrorListPrefix), "/")
+	f, err := os.Open(listFile)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	mirrors, err := parseMirrorList(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse mirror list %q: %w", listFile, err)
+	}
+	if len(mirrors) == 0 {
+		return nil, "", fmt.Errorf("no mirror is listed in %q", listFile)
+	}
+	src = &source{
+		repoURI: repoURI,
+		mirrors: mirrors,
+	}
+	return src, trimTitle(uri, repoURI), nil
+}
+
+// parseMirrorList parses a mirror list file.
+// The returned mirrors are sorted by the priority.
+func parseMirrorList(r io.Reader) ([]*mirror, error) {
+	type prioritizedMirror struct {
+		*mirror
+		priority int
+	}
+	var pms []prioritizedMirror
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		ref, plainHTTP, err := parseRepoURI(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		pm := prioritizedMirror{
+			mirror: &mirror{
+				uri:       fields[0],
+				ref:       ref,
+				plainHTTP: plainHTTP,
+			},
+		}
+		for _, f := range fields[1:] {
+			k, v, _ := strings.Cut(f, ":")
+			switch k {
+			case "priority":
+				pm.priority, err = strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid priority %q for %q: %w", v, fields[0], err)
+				}
+			default:
+				// apt's mirror+file: supports other tags such as "arch:amd64", but we ignore them
+			}
+		}
+		pms = append(pms, pm)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pms, func(i, j int) bool {
+		return pms[i].priority < pms[j].priority
+	})
+	mirrors := make([]*mirror, len(pms))
+	for i, pm := range pms {
+		mirrors[i] = pm.mirror
+	}
+	return mirrors, nil
+}
+
+// errMirrorOutOfSync is returned by resolveMirror when the mirror serves an unexpected root digest.
+var errMirrorOutOfSync = errors.New("the mirror is out of sync")
+
+// resolveMirror resolves mi.ref and sets mi.resolver, mi.fetcher, and mi.rootDesc.
+// If expectedDigest is not empty, resolveMirror fails when the mirror serves a different root digest.
+func (m *Method) resolveMirror(ctx context.Context, uri string, mi *mirror, expectedDigest digest.Digest) error {
+	refDomain := refdocker.Domain(mi.ref)
+	// The proxy is looked up with the host name, as in proxyFunc
+	host := proxyHost(refDomain)
+	if proxy, configured, err := m.config.proxyURL(host); err != nil {
+		return err
+	} else if configured {
+		proxyStr := proxyDirect
+		if proxy != nil {
+			proxyStr = redactProxy(proxy.String())
+		}
+		m.Statusf(uri, "Using proxy %q for %q", proxyStr, host)
+	}
+	if mi.plainHTTP {
+		if !m.config.allowsPlainHTTP(refDomain) {
+			return fmt.Errorf("plain HTTP is not allowed for %q (Hint: add %q to %s)", refDomain, refDomain, ConfigAllowPlainHTTP)
+		}
+		m.w.Warningf("INSECURE: using plain HTTP for %q. The connection is neither encrypted nor authenticated.", mi.ref)
+	}
+	m.Statusf(uri, "Creating a resolver for ociRef=%q", mi.ref)
+	resolver, err := m.ociResolver(mi.ref, mi.plainHTTP)
+	if err != nil {
+		return err
+	}
+
+	m.Statusf(uri, "Creating a fetcher for ociRef=%q", mi.ref)
+	fetcher, rootDesc, err := m.ociFetcher(ctx, mi.ref, resolver)
+	if err != nil {
+		return err
+	}
+	if expectedDigest != "" && rootDesc.Digest != expectedDigest {
+		return fmt.Errorf("expected %q to serve the root digest %s, got %s: %w", mi.ref, expectedDigest, rootDesc.Digest, errMirrorOutOfSync)
+	}
+	mi.resolver, mi.fetcher, mi.rootDesc = resolver, fetcher, rootDesc
+	return nil
+}
+
+// fetch fetches the file desc.
+// The chunked files are reassembled from their parts,
+// and the files in the tar layers are extracted from the layers.
+// The generated files are read from the memory.
+func (m *Method) fetch(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor) (io.ReadCloser, *mirror, error) {
+	parts, e, generated := c.source(desc)
+	switch {
+	case generated != nil:
+		return io.NopCloser(bytes.NewReader(generated)), nil, nil
+	case parts != nil:
+		return m.fetchParts(ctx, uri, c, desc, parts)
+	case e != nil:
+		return m.fetchTarEntry(ctx, uri, c, desc, *e)
+	default:
+		return m.fetchBlob(ctx, uri, c, desc)
+	}
+}
+
+// fetchBlob fetches the blob desc from the first usable mirror.
+// The mirrors are tried in the priority order on transient errors.
+// The blobs of the overlays are fetched from the overlays,
+// and the blobs with AnnotationSourceRepository are fetched from the source repositories.
+func (m *Method) fetchBlob(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor) (io.ReadCloser, *mirror, error) {
+	if ref, ok, err := sourceRepository(desc); err != nil {
+		return nil, nil, err
+	} else if ok {
+		r, err := m.fetchFromSourceRepository(ctx, uri, c, ref, desc)
+		return r, nil, err
+	}
+	if ov, ok := c.overlays[desc.Digest]; ok {
+		m.Statusf(uri, "Fetching %s from overlay %q", desc.Digest, ov.ref)
+		r, err := ov.fetcher.Fetch(ctx, desc)
+		return r, nil, err
+	}
+	var mirrors []*mirror
+	for _, mi := range c.mirrors {
+		if mi.err == nil {
+			mirrors = append(mirrors, mi)
+		}
+	}
+	if len(mirrors) == 0 {
+		// All the mirrors have failed before, but the errors might have been transient
+		mirrors = c.mirrors
+	}
+	var lastErr error
+	for _, mi := range mirrors {
+		if mi.fetcher == nil {
+			if err := m.resolveMirror(ctx, uri, mi, c.rootDesc.Digest); err != nil {
+				mi.err = err
+				lastErr = err
+				m.w.Warningf("Skipping mirror %q: %v", mi.uri, err)
+				continue
+			}
+		}
+		r, err := mi.fetcher.Fetch(ctx, desc)
+		if err == nil {
+			return r, mi, nil
+		}
+		lastErr = err
+		if !isTransientError(err) {
+			return nil, mi, err
+		}
+		mi.err = err
+		if mi.uri != "" {
+			m.w.Warningf("Skipping mirror %q: %v", mi.uri, err)
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no usable mirror")
+	}
+	return nil, nil, lastErr
+}
+
+// isTransientError returns true if err is likely to be resolved by retrying,
+// or by trying another mirror.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == 429 || statusErr.StatusCode >= 500
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
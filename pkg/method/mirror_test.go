@@ -0,0 +1,161 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParseMirrorList(t *testing.T) {
+	const list = `# comment
+oci://harbor.example.com/foo/bar:latest priority:2
+oci://ghcr.io/foo/bar:latest priority:1 arch:amd64
+
+oci://registry.example.com/foo/bar:latest/ priority:2
+`
+	mirrors, err := parseMirrorList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"ghcr.io/foo/bar:latest",
+		"harbor.example.com/foo/bar:latest",
+		"registry.example.com/foo/bar:latest",
+	}
+	if len(mirrors) != len(expected) {
+		t.Fatalf("expected %d mirrors, got %d", len(expected), len(mirrors))
+	}
+	for i, mi := range mirrors {
+		if mi.ref.String() != expected[i] {
+			t.Fatalf("expected mirror %d to be %q, got %q", i, expected[i], mi.ref.String())
+		}
+	}
+	if mirrors[2].uri != "oci://registry.example.com/foo/bar:latest/" {
+		t.Fatalf("unexpected uri: %q", mirrors[2].uri)
+	}
+
+	if _, err := parseMirrorList(strings.NewReader("ghcr.io/foo/bar:latest\n")); err == nil {
+		t.Fatal("expected error for a mirror without the oci:// prefix")
+	}
+}
+
+// newTestRegistry serves the blobs of f as "foo:latest" pointing to root, over plain HTTP.
+// The requests with the methods in failMethods fail with 503.
+func newTestRegistry(t testing.TB, f memFetcher, root ocispec.Descriptor, failMethods ...string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range failMethods {
+			if r.Method == method {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		var dgst digest.Digest
+		switch p := r.URL.Path; {
+		case p == "/v2/foo/manifests/latest":
+			dgst = root.Digest
+			w.Header().Set("Content-Type", root.MediaType)
+		case strings.HasPrefix(p, "/v2/foo/manifests/"), strings.HasPrefix(p, "/v2/foo/blobs/"):
+			dgst = digest.Digest(p[strings.LastIndex(p, "/")+1:])
+		}
+		b, ok := f[dgst]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestMirror returns the mirror of "foo:latest" in srv.
+func newTestMirror(t testing.TB, srv *httptest.Server) *mirror {
+	refStr := strings.TrimPrefix(srv.URL, "http://") + "/foo:latest"
+	ref, err := refdocker.ParseDockerRef(refStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mirror{uri: SchemeOCIPlainHTTP + refStr, ref: ref, plainHTTP: true}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	f := make(memFetcher)
+	empty := f.add(MediaTypeEmptyJSON, []byte("{}"))
+	addIndex := func(content string) ocispec.Descriptor {
+		layer := f.add(MediaTypeApplicationOctetStream, []byte(content))
+		layer.Annotations = map[string]string{ocispec.AnnotationTitle: "dists/stable/InRelease"}
+		manifest := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    empty,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+		return f.addJSON(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{manifest},
+		})
+	}
+	root := addIndex("InRelease 1")
+	otherRoot := addIndex("InRelease 2")
+
+	unavailable := newTestRegistry(t, f, root, http.MethodHead, http.MethodGet)
+	// unavailableGet resolves the tag, but fails to serve the blobs
+	unavailableGet := newTestRegistry(t, f, root, http.MethodGet)
+	available := newTestRegistry(t, f, root)
+	outOfSync := newTestRegistry(t, f, otherRoot)
+
+	newMethod := func() *Method {
+		m := New(io.Discard, nil)
+		m.config = config{
+			strings.ToLower(ConfigHostsDir):   {t.TempDir()},
+			strings.ToLower(ConfigLedgerMode): {ledgerModeFail},
+			strings.ToLower(ConfigLedgerDir):  {t.TempDir()},
+		}
+		for _, srv := range []*httptest.Server{unavailable, unavailableGet, available, outOfSync} {
+			host := strings.TrimPrefix(srv.URL, "http://")
+			m.config[strings.ToLower(ConfigAllowPlainHTTP)] = append(m.config[strings.ToLower(ConfigAllowPlainHTTP)], host)
+		}
+		return m
+	}
+	const uri = "oci+mirror+file:/etc/apt/mirrors.txt/dists/stable/InRelease"
+
+	// The next mirror is used on a transient error
+	m := newMethod()
+	src := &source{mirrors: []*mirror{newTestMirror(t, unavailable), newTestMirror(t, available)}}
+	c, desc, failed, err := m.lookupFile(ctx, uri, []*source{src}, "dists/stable/InRelease", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != nil || c.rootDesc.Digest != root.Digest || desc.Digest != digest.FromString("InRelease 1") {
+		t.Fatalf("unexpected result: failed=%v, root=%s, desc=%+v", failed, c.rootDesc.Digest, desc)
+	}
+	if !isTransientError(src.mirrors[0].err) || src.mirrors[1].err != nil {
+		t.Fatalf("expected only the first mirror to fail with a transient error, got %v, %v", src.mirrors[0].err, src.mirrors[1].err)
+	}
+
+	// The mirror that serves another root digest is rejected,
+	// after the first mirror resolved the tag and failed later
+	m = newMethod()
+	src = &source{mirrors: []*mirror{newTestMirror(t, unavailableGet), newTestMirror(t, outOfSync)}}
+	_, _, failed, err = m.lookupFile(ctx, uri, []*source{src}, "dists/stable/InRelease", "")
+	if !errors.Is(err, errMirrorOutOfSync) {
+		t.Fatalf("expected errMirrorOutOfSync, got %v", err)
+	}
+	if failed != src.mirrors[1] {
+		t.Fatalf("expected the out-of-sync mirror to be returned as the failed mirror, got %+v", failed)
+	}
+}
@@ -0,0 +1,143 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// AnnotationOverlay is the annotation of the root manifest or index that lists the references
+// whose file maps are merged beneath the file map of the root, separated by ",".
+// The references are listed from the lowest precedence to the highest, e.g.,
+// "ghcr.io/foo/base@sha256:<hex>,ghcr.io/foo/hotfix:latest".
+// The files of the root itself have the highest precedence.
+//
+// ":<tag>" and "@<digest>" refer to the same repository as the root,
+// e.g., "@sha256:<hex>,:hotfix".
+//
+// The annotation of the overlays themselves is not followed.
+const AnnotationOverlay = "dev.apt-transport-oci.overlay"
+
+// parseOverlayRefs parses the value of AnnotationOverlay.
+// root is used for the references without the repository.
+func parseOverlayRefs(s string, root refdocker.Named) ([]refdocker.Named, error) {
+	var refs []refdocker.Named
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if strings.HasPrefix(f, ":") || strings.HasPrefix(f, "@") {
+			f = root.Name() + f
+		}
+		ref, err := refdocker.ParseDockerRef(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q in the %s annotation: %w", f, AnnotationOverlay, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// overlayMirrors returns the overlays of the resolved mirror mi, from the lowest precedence to the highest.
+// The overlays are not resolved yet.
+func overlayMirrors(ctx context.Context, mi *mirror) ([]*mirror, error) {
+	annotations, err := rootAnnotations(ctx, mi)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := annotations[AnnotationOverlay]
+	if !ok {
+		return nil, nil
+	}
+	refs, err := parseOverlayRefs(s, mi.ref)
+	if err != nil {
+		return nil, err
+	}
+	overlays := make([]*mirror, len(refs))
+	for i, ref := range refs {
+		overlays[i] = &mirror{
+			ref: ref,
+			// The plain HTTP mode is inherited only within the same registry
+			plainHTTP: mi.plainHTTP && refdocker.Domain(ref) == refdocker.Domain(mi.ref),
+		}
+	}
+	return overlays, nil
+}
+
+// buildOverlayFileMap builds the file map of the resolved mirror mi, merged with the file maps of its overlays.
+// The file map of a mirror without overlays is returned while it is being built. See filemap.go.
+// The overlays are checked against pol, and verified in the same way as mi.
+// owners maps the digests of the blobs served from the overlays to the overlays.
+func (m *Method) buildOverlayFileMap(ctx context.Context, uri string, mi *mirror, pol *policy, verifierNames []string) (fm *fileMap, owners map[digest.Digest]*mirror, err error) {
+	overlays, err := overlayMirrors(ctx, mi)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.Statusf(uri, "Building file map for rootDesc=%+v", mi.rootDesc)
+	if len(overlays) == 0 {
+		// Built in the background, without waiting for all the manifests
+		return startFileMap(ctx, mi.fetcher, mi.rootDesc, m.tarSpool()), nil, nil
+	}
+	rootFM, err := buildFileMap(ctx, mi.fetcher, mi.rootDesc, m.tarSpool())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fm = newFileMap()
+	fileOwners := make(map[string]*mirror)
+	for _, ov := range overlays {
+		if err := pol.check(ov.ref); err != nil {
+			return nil, nil, err
+		}
+		if err := pol.checkSignature(ov.ref, verifierNames); err != nil {
+			return nil, nil, err
+		}
+		err := m.resolveMirror(ctx, uri, ov, "")
+		if err == nil {
+			err = m.verifySignatures(ctx, uri, ov)
+		}
+		if err == nil {
+			err = m.checkLedger(ctx, uri, ov)
+		}
+		var ovFM *fileMap
+		if err == nil {
+			m.Statusf(uri, "Building file map for overlay %q (rootDesc=%+v)", ov.ref, ov.rootDesc)
+			ovFM, err = buildFileMap(ctx, ov.fetcher, ov.rootDesc, m.tarSpool())
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("overlay %q: %w", ov.ref, err)
+		}
+		for title, desc := range ovFM.files {
+			fm.files[title], fileOwners[title] = desc, ov
+		}
+		maps.Copy(fm.parts, ovFM.parts)
+		maps.Copy(fm.entries, ovFM.entries)
+	}
+	for title, desc := range rootFM.files {
+		fm.files[title] = desc
+		delete(fileOwners, title)
+	}
+	maps.Copy(fm.parts, rootFM.parts)
+	maps.Copy(fm.entries, rootFM.entries)
+	// The repository config of the overlays is not used
+	fm.repoConfig = rootFM.repoConfig
+
+	owners = make(map[digest.Digest]*mirror)
+	for title, ov := range fileOwners {
+		for _, dgst := range fm.blobs(fm.files[title]) {
+			owners[dgst] = ov
+		}
+	}
+	// The blobs of the root can be fetched from the mirrors of the root
+	for _, desc := range rootFM.files {
+		for _, dgst := range rootFM.blobs(desc) {
+			delete(owners, dgst)
+		}
+	}
+	return fm, owners, nil
+}
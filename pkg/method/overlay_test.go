@@ -0,0 +1,37 @@
+package method
+
+import (
+	"reflect"
+	"testing"
+
+	refdocker "github.com/distribution/reference"
+)
+
+func TestParseOverlayRefs(t *testing.T) {
+	root, err := refdocker.ParseDockerRef("ghcr.io/foo/bar:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dgst = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	refs, err := parseOverlayRefs("ghcr.io/foo/base@"+dgst+", :hotfix,@"+dgst+",,debian", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.String())
+	}
+	expected := []string{
+		"ghcr.io/foo/base@" + dgst,
+		"ghcr.io/foo/bar:hotfix",
+		"ghcr.io/foo/bar@" + dgst,
+		"docker.io/library/debian:latest",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if _, err := parseOverlayRefs("ghcr.io/foo/Invalid", root); err == nil {
+		t.Fatal("expected an error for an invalid reference")
+	}
+}
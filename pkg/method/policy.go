@@ -0,0 +1,160 @@
+package method
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	refdocker "github.com/distribution/reference"
+)
+
+const (
+	// ConfigPolicy is the policy file that restricts the registries and the repositories.
+	ConfigPolicy = "Acquire::oci::Policy"
+
+	// DefaultPolicy is the default value of ConfigPolicy.
+	// When the default policy file does not exist, all the references are allowed.
+	DefaultPolicy = "/etc/apt/apt-transport-oci/policy.json"
+
+	// FailReasonPolicyViolation is the FailReason for the references disallowed by the policy.
+	FailReasonPolicyViolation = "PolicyViolation"
+)
+
+// Values of policy.Default.
+const (
+	policyAccept = "accept"
+	policyReject = "reject"
+)
+
+// policy is the allowlist of the registries and the repositories,
+// similar in spirit to containers-policy.json(5).
+//
+//	{
+//	  "default": "reject",
+//	  "scopes": {
+//	    "ghcr.io/akihirosuda": {},
+//	    "registry.example.com/debian": {"requireDigest": true},
+//	    "registry.example.com": {"requireSignature": ["cosign"]},
+//	    "registry.example.com/untrusted": {"reject": true}
+//	  }
+//	}
+//
+// A scope is a registry ("ghcr.io"), or a repository prefix ("ghcr.io/akihirosuda") that matches
+// the repositories under it. The most specific scope is used.
+type policy struct {
+	// Default is "accept" or "reject" (default) for the references that do not match any scope
+	Default string                       `json:"default,omitempty"`
+	Scopes  map[string]policyRequirement `json:"scopes"`
+}
+
+// policyRequirement is the requirement for a scope.
+type policyRequirement struct {
+	// Reject rejects the scope
+	Reject bool `json:"reject,omitempty"`
+	// RequireTag requires the reference to have a tag
+	RequireTag bool `json:"requireTag,omitempty"`
+	// RequireDigest requires the reference to have a digest, like "oci://ghcr.io/foo/bar@sha256:<hex>/"
+	RequireDigest bool `json:"requireDigest,omitempty"`
+	// RequireSignature requires the signature verifiers ("cosign", "notation") to be configured
+	RequireSignature []string `json:"requireSignature,omitempty"`
+}
+
+// loadPolicy loads the policy file.
+// A nil policy is returned when the default policy file does not exist.
+func loadPolicy(file string) (*policy, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && file == DefaultPolicy {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pol policy
+	if err := json.Unmarshal(b, &pol); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+	}
+	switch pol.Default {
+	case "":
+		pol.Default = policyReject
+	case policyAccept, policyReject:
+	default:
+		return nil, fmt.Errorf("%q: unknown default %q", file, pol.Default)
+	}
+	return &pol, nil
+}
+
+// policy returns the policy, loading it on the first call.
+func (m *Method) policy() (*policy, error) {
+	if !m.policyLoaded {
+		pol, err := loadPolicy(m.config.GetDefault(ConfigPolicy, DefaultPolicy))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the policy: %w", err)
+		}
+		m.pol, m.policyLoaded = pol, true
+	}
+	return m.pol, nil
+}
+
+// requirement returns the requirement for the reference.
+func (pol *policy) requirement(ref refdocker.Named) (*policyRequirement, error) {
+	if pol == nil {
+		return &policyRequirement{}, nil
+	}
+	name := ref.Name()
+	var (
+		matched string
+		req     policyRequirement
+	)
+	for scope, r := range pol.Scopes {
+		scope = strings.TrimSuffix(scope, "/")
+		if (name == scope || strings.HasPrefix(name, scope+"/")) && len(scope) > len(matched) {
+			matched, req = scope, r
+		}
+	}
+	if matched == "" && pol.Default != policyAccept {
+		return nil, fmt.Errorf("%q is not allowed by the policy", name)
+	}
+	if req.Reject {
+		return nil, fmt.Errorf("%q is rejected by the policy (scope %q)", name, matched)
+	}
+	return &req, nil
+}
+
+// check checks the reference against the policy, without network access.
+// The signature requirement is checked by checkSignature.
+func (pol *policy) check(ref refdocker.Named) error {
+	req, err := pol.requirement(ref)
+	if err == nil {
+		// The tag of a tagless source is chosen from the suite later
+		if _, ok := ref.(refdocker.Tagged); req.RequireTag && !ok && !refdocker.IsNameOnly(ref) {
+			err = fmt.Errorf("%q lacks a tag, which is required by the policy", ref)
+		}
+		if _, ok := ref.(refdocker.Digested); req.RequireDigest && !ok {
+			err = fmt.Errorf("%q lacks a digest, which is required by the policy", ref)
+		}
+	}
+	if err != nil {
+		return &failReasonError{reason: FailReasonPolicyViolation, err: err}
+	}
+	return nil
+}
+
+// checkSignature checks that the signature verifiers required by the policy are configured.
+func (pol *policy) checkSignature(ref refdocker.Named, verifierNames []string) error {
+	req, err := pol.requirement(ref)
+	if err == nil {
+		for _, name := range req.RequireSignature {
+			if !slices.Contains(verifierNames, name) {
+				err = fmt.Errorf("%q requires %s signature verification by the policy, but it is not configured", ref.Name(), name)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return &failReasonError{reason: FailReasonPolicyViolation, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package method
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/apt"
+	refdocker "github.com/distribution/reference"
+)
+
+func TestPolicy(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(f, []byte(`{
+  "scopes": {
+    "ghcr.io/akihirosuda": {},
+    "registry.example.com/debian": {"requireDigest": true},
+    "registry.example.com": {"requireSignature": ["cosign"]},
+    "registry.example.com/debian/untrusted": {"reject": true}
+  }
+}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := loadPolicy(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dgst = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for ref, allowed := range map[string]bool{
+		"ghcr.io/akihirosuda/apt-transport-oci-examples:latest":  true,
+		"ghcr.io/akihirosuda2/apt-transport-oci-examples:latest": false,
+		"docker.io/library/debian:latest":                        false,
+		"registry.example.com/debian/bookworm:latest":            false,
+		"registry.example.com/debian/bookworm@" + dgst:           true,
+		"registry.example.com/debian/untrusted@" + dgst:          false,
+		"registry.example.com/ubuntu:latest":                     true,
+	} {
+		named, err := refdocker.ParseDockerRef(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pol.check(named)
+		if allowed && err != nil {
+			t.Errorf("expected %q to be allowed, got %v", ref, err)
+		} else if !allowed && failReason(err) != FailReasonPolicyViolation {
+			t.Errorf("expected %q to be disallowed, got %v", ref, err)
+		}
+	}
+
+	named, err := refdocker.ParseDockerRef("registry.example.com/ubuntu:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pol.checkSignature(named, []string{"notation"}); err == nil {
+		t.Error("expected an error when cosign is not configured")
+	}
+	if err := pol.checkSignature(named, []string{"cosign"}); err != nil {
+		t.Error(err)
+	}
+
+	msg := &apt.Message{
+		StatusCode: CodeURIAcquire,
+		Fields: map[string]string{
+			FieldURI: "oci://docker.io/library/debian:latest/dists/bookworm/InRelease",
+		},
+	}
+	var fre *failReasonError
+	if _, _, err := parseURIFields(msg, pol); !errors.As(err, &fre) {
+		t.Errorf("expected parseURIFields to fail with failReasonError, got %v", err)
+	}
+	if _, _, err := parseURIFields(msg, nil); err != nil {
+		t.Errorf("expected nil policy to allow any reference, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package method
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config items for proxies.
+// The values are like "http://proxy.example.com:3128", "socks5h://proxy.example.com:1080", or "DIRECT".
+// Per-host items such as "Acquire::oci::Proxy::ghcr.io" take precedence over the global ones.
+const (
+	ConfigProxy      = "Acquire::oci::Proxy"
+	ConfigHTTPSProxy = "Acquire::https::Proxy" // fallback
+)
+
+// proxyDirect disables the proxy.
+const proxyDirect = "DIRECT"
+
+// proxyURL returns the proxy URL for host, in the following order:
+//
+//   - Acquire::oci::Proxy::<host>
+//   - Acquire::oci::Proxy
+//   - Acquire::https::Proxy::<host>
+//   - Acquire::https::Proxy
+//
+// proxyURL returns (nil, true, nil) for "DIRECT".
+// proxyURL returns (nil, false, nil) when no proxy is configured.
+func (c config) proxyURL(host string) (u *url.URL, configured bool, err error) {
+	for _, k := range []string{
+		ConfigProxy + "::" + host,
+		ConfigProxy,
+		ConfigHTTPSProxy + "::" + host,
+		ConfigHTTPSProxy,
+	} {
+		v := c.Get(k)
+		if v == "" {
+			continue
+		}
+		if strings.EqualFold(v, proxyDirect) {
+			return nil, true, nil
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			// *url.Error contains the URL with the credentials, so only the underlying error is reported
+			if uerr, ok := err.(*url.Error); ok {
+				err = uerr.Err
+			}
+			return nil, true, fmt.Errorf("failed to parse %s=%q: %v", k, redactProxy(v), err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, true, fmt.Errorf("unsupported proxy scheme %q (%s)", u.Scheme, k)
+		}
+		return u, true, nil
+	}
+	return nil, false, nil
+}
+
+// proxyFunc returns a function for http.Transport.Proxy.
+// When no proxy is configured in apt, the proxy environment variables are used.
+func (c config) proxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, configured, err := c.proxyURL(req.URL.Hostname())
+		if err != nil || configured {
+			return u, err
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+// proxyHost returns the host name passed to proxyURL by proxyFunc for the requests to refDomain,
+// i.e., the API host name without the port, e.g., "docker.io" -> "registry-1.docker.io", "localhost:5000" -> "localhost".
+func proxyHost(refDomain string) string {
+	if refDomain == "docker.io" {
+		refDomain = "registry-1.docker.io"
+	}
+	return (&url.URL{Host: refDomain}).Hostname()
+}
+
+// redactProxy redacts the credentials in the proxy URL.
+func redactProxy(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "(unparsable)"
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	return u.String()
+}
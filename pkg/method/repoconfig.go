@@ -0,0 +1,135 @@
+package method
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The repository configs of the manifests of an index are merged in the order of the index,
+// regardless of the order of the concurrent fetches.
+// The manifests are selected as in the file map. See artifact.go.
+
+// repoConfigs collects the repository configs of the manifests.
+type repoConfigs struct {
+	mu sync.Mutex
+	// children maps the digests of the indexes to the selected manifests
+	children map[digest.Digest][]ocispec.Descriptor
+	// configs maps the digests of the manifests to the repository configs
+	configs map[digest.Digest]*repoconfig.Config
+}
+
+func newRepoConfigs() *repoConfigs {
+	return &repoConfigs{
+		children: make(map[digest.Digest][]ocispec.Descriptor),
+		configs:  make(map[digest.Digest]*repoconfig.Config),
+	}
+}
+
+func (rcs *repoConfigs) addIndex(index digest.Digest, children []ocispec.Descriptor) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	rcs.children[index] = children
+}
+
+func (rcs *repoConfigs) addManifest(manifest digest.Digest, rc *repoconfig.Config) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	rcs.configs[manifest] = rc
+}
+
+// merged returns the repository configs under rootDesc merged in the order of the indexes,
+// or nil if there is none.
+func (rcs *repoConfigs) merged(rootDesc ocispec.Descriptor) *repoconfig.Config {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+	var merged *repoconfig.Config
+	var walk func(dgst digest.Digest)
+	walk = func(dgst digest.Digest) {
+		if rc, ok := rcs.configs[dgst]; ok {
+			if merged == nil {
+				merged = &repoconfig.Config{}
+			}
+			merged.Merge(rc)
+			return
+		}
+		for _, child := range rcs.children[dgst] {
+			walk(child.Digest)
+		}
+	}
+	walk(rootDesc.Digest)
+	return merged
+}
+
+// readManifestRepoConfig reads the repository config of the manifest, or returns nil if there is none.
+func readManifestRepoConfig(ctx context.Context, fetcher remotes.Fetcher, manifest *ocispec.Manifest) (*repoconfig.Config, error) {
+	if manifest.Config.MediaType != repoconfig.MediaType {
+		return nil, nil
+	}
+	blob, err := ociutil.ReadBlob(ctx, fetcher, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	return repoconfig.Parse(blob)
+}
+
+// ReadRepoConfig reads the repository config of rootDesc, which is a manifest or an index,
+// without reading the layers.
+// The manifests are selected as in the file map, and their configs are merged in the order of the indexes.
+// nil is returned when there is no config.
+func ReadRepoConfig(ctx context.Context, fetcher remotes.Fetcher, rootDesc ocispec.Descriptor) (*repoconfig.Config, error) {
+	rcs := newRepoConfigs()
+	handler := func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		switch desc.MediaType {
+		case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+			blob, err := ociutil.ReadBlob(ctx, fetcher, desc)
+			if err != nil {
+				return nil, err
+			}
+			var manifest ocispec.Manifest
+			if err := json.Unmarshal(blob, &manifest); err != nil {
+				return nil, err
+			}
+			if isUnrelatedManifest(&manifest) {
+				if desc.Digest == rootDesc.Digest {
+					return nil, fmt.Errorf("unexpected artifact type %q of %s", artifactType(&manifest), desc.Digest)
+				}
+				return nil, nil
+			}
+			rc, err := readManifestRepoConfig(ctx, fetcher, &manifest)
+			if err != nil || rc == nil {
+				return nil, err
+			}
+			rcs.addManifest(desc.Digest, rc)
+		case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+			blob, err := ociutil.ReadBlob(ctx, fetcher, desc)
+			if err != nil {
+				return nil, err
+			}
+			var index ocispec.Index
+			if err := json.Unmarshal(blob, &index); err != nil {
+				return nil, err
+			}
+			children := selectManifests(index.Manifests)
+			rcs.addIndex(desc.Digest, children)
+			return children, nil
+		default:
+			if desc.Digest == rootDesc.Digest {
+				return nil, fmt.Errorf("unexpected media type %q of %s", desc.MediaType, desc.Digest)
+			}
+		}
+		return nil, nil
+	}
+	if err := images.Walk(ctx, images.HandlerFunc(handler), rootDesc); err != nil {
+		return nil, err
+	}
+	return rcs.merged(rootDesc), nil
+}
@@ -0,0 +1,61 @@
+package method
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReadRepoConfig(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	addManifest := func(rc *repoconfig.Config, subject *ocispec.Descriptor) ocispec.Descriptor {
+		return f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    f.addJSON(t, repoconfig.MediaType, rc),
+			Subject:   subject,
+		})
+	}
+	amd64 := addManifest(&repoconfig.Config{Suites: []string{"stable"}, Architectures: []string{"amd64"}, Origin: "Example"}, nil)
+	arm64 := addManifest(&repoconfig.Config{Suites: []string{"stable"}, Architectures: []string{"arm64"}, Origin: "Other", SignedBy: "0123"}, nil)
+	image := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    f.addJSON(t, ocispec.MediaTypeImageConfig, struct{}{}),
+	})
+	// The attestation manifests of BuildKit are not selected, as in the file map
+	attestation := addManifest(&repoconfig.Config{Suites: []string{"attestation"}}, nil)
+	attestation.Annotations = map[string]string{annotationDockerReferenceType: "attestation-manifest"}
+	index := f.addJSON(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{image, amd64, arm64, attestation},
+	})
+
+	expected := &repoconfig.Config{
+		Suites:        []string{"stable"},
+		Architectures: []string{"amd64", "arm64"},
+		Origin:        "Example",
+		SignedBy:      "0123",
+	}
+	rc, err := ReadRepoConfig(ctx, f, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rc, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, rc)
+	}
+	// The file map has the same config
+	fm, err := buildFileMap(ctx, f, index, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fm.config(), expected) {
+		t.Fatalf("expected %+v, got %+v", expected, fm.config())
+	}
+
+	if rc, err := ReadRepoConfig(ctx, f, image); err != nil || rc != nil {
+		t.Fatalf("expected no config, got %+v, %v", rc, err)
+	}
+}
@@ -0,0 +1,444 @@
+package method
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The tar layers in the eStargz format and in the zstd:chunked format have the table of contents (TOC),
+// so the files can be fetched with ranged requests, without downloading the whole layer.
+//
+//   - eStargz: https://github.com/containerd/stargz-snapshotter/blob/main/docs/estargz.md
+//   - zstd:chunked: https://github.com/containers/storage/blob/main/docs/containers-storage-zstd-chunked.md
+//
+// The TOC is verified with the digest in the layer annotation, and each chunk of the files is verified
+// with the chunk digest in the TOC, as the layer digest cannot be verified without downloading the whole layer.
+const (
+	// AnnotationStargzTOCDigest is the digest of the TOC JSON of an eStargz layer.
+	AnnotationStargzTOCDigest = "containerd.io/snapshot/stargz/toc.digest"
+
+	// AnnotationZstdChunkedManifestChecksum is the digest of the compressed TOC of a zstd:chunked layer.
+	AnnotationZstdChunkedManifestChecksum = "io.github.containers.zstd-chunked.manifest-checksum"
+
+	// AnnotationZstdChunkedManifestPosition is "offset:length:lengthUncompressed:type" of the compressed TOC of a zstd:chunked layer.
+	AnnotationZstdChunkedManifestPosition = "io.github.containers.zstd-chunked.manifest-position"
+)
+
+const (
+	// stargzFooterSize is the maximum size of the eStargz footer, which is an empty gzip member
+	// with the TOC offset in the extra field.
+	stargzFooterSize = 51
+	stargzTOCTarName = "stargz.index.json"
+)
+
+// toc is the TOC of eStargz and zstd:chunked.
+type toc struct {
+	Version int        `json:"version"`
+	Entries []tocEntry `json:"entries"`
+}
+
+type tocEntry struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size,omitempty"`
+	LinkName string `json:"linkName,omitempty"`
+	// Digest is the digest of the whole file
+	Digest string `json:"digest,omitempty"`
+	// Offset is the offset of the compressed chunk in the layer
+	Offset int64 `json:"offset,omitempty"`
+	// EndOffset is the end of the compressed chunk in the layer (zstd:chunked only)
+	EndOffset   int64  `json:"endOffset,omitempty"`
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+	// ChunkType is "zeros" for the holes of the sparse files (zstd:chunked only)
+	ChunkType string `json:"chunkType,omitempty"`
+}
+
+// tocChunk is a chunk of a file in a seekable layer.
+type tocChunk struct {
+	// desc has the digest and the size of the uncompressed chunk
+	desc ocispec.Descriptor
+	// offset and end are the range of the compressed chunk in the layer
+	offset, end int64
+	// zeros is true for the holes of the sparse files
+	zeros bool
+}
+
+// isSeekableLayer returns true if the layer has the annotations of eStargz or zstd:chunked.
+func isSeekableLayer(layer ocispec.Descriptor) bool {
+	_, stargz := layer.Annotations[AnnotationStargzTOCDigest]
+	_, zstdChunked := layer.Annotations[AnnotationZstdChunkedManifestChecksum]
+	return stargz || zstdChunked
+}
+
+// readTOC reads the TOC of the seekable layer with ranged requests.
+// tocOffset is the offset of the TOC in the layer, which is the end of the last chunk.
+func readTOC(ctx context.Context, fetcher remotes.Fetcher, layer ocispec.Descriptor) (t *toc, tocOffset int64, err error) {
+	var b []byte
+	if s, ok := layer.Annotations[AnnotationStargzTOCDigest]; ok {
+		b, tocOffset, err = readStargzTOC(ctx, fetcher, layer, s)
+	} else {
+		b, tocOffset, err = readZstdChunkedTOC(ctx, fetcher, layer)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	t = &toc{}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse the TOC: %w", err)
+	}
+	if t.Version != 1 {
+		return nil, 0, fmt.Errorf("unsupported TOC version %d", t.Version)
+	}
+	return t, tocOffset, nil
+}
+
+// readStargzTOC reads the TOC JSON of an eStargz layer, and verifies it with tocDigest.
+func readStargzTOC(ctx context.Context, fetcher remotes.Fetcher, layer ocispec.Descriptor, tocDigest string) ([]byte, int64, error) {
+	dgst, err := digest.Parse(tocDigest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid %s: %w", AnnotationStargzTOCDigest, err)
+	}
+	tailSize := min(layer.Size, stargzFooterSize)
+	tail, err := ociutil.ReadRange(ctx, fetcher, layer, layer.Size-tailSize, tailSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	tocOffset, footerSize, err := parseStargzFooter(tail)
+	if err != nil {
+		return nil, 0, err
+	}
+	footerOffset := layer.Size - footerSize
+	if tocOffset < 0 || tocOffset > footerOffset {
+		return nil, 0, fmt.Errorf("invalid TOC offset %d", tocOffset)
+	}
+	compressed, err := ociutil.ReadRange(ctx, fetcher, layer, tocOffset, footerOffset-tocOffset)
+	if err != nil {
+		return nil, 0, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, 0, err
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, 0, err
+	}
+	if hdr.Name != stargzTOCTarName {
+		return nil, 0, fmt.Errorf("expected %q, got %q", stargzTOCTarName, hdr.Name)
+	}
+	b, err := io.ReadAll(io.LimitReader(tr, ociutil.MaxBlobSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(b) > ociutil.MaxBlobSize {
+		return nil, 0, errors.New("the TOC is too large")
+	}
+	if dgst.Algorithm().FromBytes(b) != dgst {
+		return nil, 0, fmt.Errorf("digest mismatch of the TOC, expected %s", dgst)
+	}
+	return b, tocOffset, nil
+}
+
+// parseStargzFooter parses the eStargz footer at the end of tail, and returns the TOC offset and the footer size.
+// The footer is usually 51 bytes, but the size of the empty deflate stream depends on the compressor.
+func parseStargzFooter(tail []byte) (tocOffset, footerSize int64, err error) {
+	const subfieldLen = 16 + len("STARGZ")
+	for i := 0; i+10 < len(tail); i++ {
+		if !bytes.HasPrefix(tail[i:], gzipMagic) {
+			continue
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(tail[i:]))
+		if err != nil {
+			continue
+		}
+		extra := zr.Header.Extra
+		if len(extra) != 4+subfieldLen || extra[0] != 'S' || extra[1] != 'G' ||
+			int(binary.LittleEndian.Uint16(extra[2:4])) != subfieldLen || string(extra[4+16:]) != "STARGZ" {
+			continue
+		}
+		tocOffset, err := strconv.ParseInt(string(extra[4:4+16]), 16, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid eStargz footer: %w", err)
+		}
+		return tocOffset, int64(len(tail) - i), nil
+	}
+	return 0, 0, errors.New("eStargz footer not found")
+}
+
+// readZstdChunkedTOC reads the TOC JSON of a zstd:chunked layer, and verifies it with the manifest checksum.
+func readZstdChunkedTOC(ctx context.Context, fetcher remotes.Fetcher, layer ocispec.Descriptor) ([]byte, int64, error) {
+	dgst, err := digest.Parse(layer.Annotations[AnnotationZstdChunkedManifestChecksum])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid %s: %w", AnnotationZstdChunkedManifestChecksum, err)
+	}
+	pos := strings.Split(layer.Annotations[AnnotationZstdChunkedManifestPosition], ":")
+	if len(pos) != 4 {
+		return nil, 0, fmt.Errorf("invalid %s", AnnotationZstdChunkedManifestPosition)
+	}
+	var nums [3]int64
+	for i := range nums {
+		if nums[i], err = strconv.ParseInt(pos[i], 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid %s: %w", AnnotationZstdChunkedManifestPosition, err)
+		}
+	}
+	offset, length, lengthUncompressed := nums[0], nums[1], nums[2]
+	if lengthUncompressed < 0 || lengthUncompressed > ociutil.MaxBlobSize {
+		return nil, 0, errors.New("the TOC is too large")
+	}
+	compressed, err := ociutil.ReadRange(ctx, fetcher, layer, offset, length)
+	if err != nil {
+		return nil, 0, err
+	}
+	if dgst.Algorithm().FromBytes(compressed) != dgst {
+		return nil, 0, fmt.Errorf("digest mismatch of the TOC, expected %s", dgst)
+	}
+	zr, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(ociutil.MaxBlobSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer zr.Close()
+	b, err := zr.DecodeAll(compressed, make([]byte, 0, lengthUncompressed))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(b)) != lengthUncompressed {
+		return nil, 0, fmt.Errorf("expected the TOC to be %d bytes, got %d", lengthUncompressed, len(b))
+	}
+	return b, offset, nil
+}
+
+// readSeekableLayer reads the TOC of the seekable layer, and returns the index of the layer.
+// The files are not downloaded.
+func readSeekableLayer(ctx context.Context, fetcher remotes.Fetcher, layer ocispec.Descriptor, compression string) (*tarLayer, error) {
+	t, tocOffset, err := readTOC(ctx, fetcher, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	// The end of a compressed chunk is the next offset, unless the TOC has EndOffset
+	offsets := []int64{tocOffset}
+	for _, e := range t.Entries {
+		if e.Offset > 0 {
+			offsets = append(offsets, e.Offset)
+		}
+	}
+	slices.Sort(offsets)
+	offsets = slices.Compact(offsets)
+	chunkRange := func(e tocEntry) (int64, int64, error) {
+		end := e.EndOffset
+		if end == 0 {
+			i, _ := slices.BinarySearch(offsets, e.Offset+1)
+			if i == len(offsets) {
+				return 0, 0, fmt.Errorf("invalid offset %d of %q", e.Offset, e.Name)
+			}
+			end = offsets[i]
+		}
+		if e.Offset <= 0 || end <= e.Offset || end > layer.Size {
+			return 0, 0, fmt.Errorf("invalid range %d-%d of %q", e.Offset, end, e.Name)
+		}
+		return e.Offset, end, nil
+	}
+
+	tl := &tarLayer{
+		files: make(map[string]tarFile),
+	}
+	for _, e := range t.Entries {
+		p := cleanTarPath(e.Name)
+		if p == "" {
+			continue
+		}
+		dir, base := path.Split(p)
+		if base == whiteoutOpaque {
+			tl.opaques = append(tl.opaques, strings.TrimSuffix(dir, "/"))
+			continue
+		}
+		if hidden, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			tl.whiteouts = append(tl.whiteouts, dir+hidden)
+			continue
+		}
+		switch e.Type {
+		case "reg":
+			dgst, err := digest.Parse(e.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid digest of %q: %w", e.Name, err)
+			}
+			entry := tarEntry{layer: layer, name: p, compression: compression, chunks: []tocChunk{}}
+			if e.Size > 0 {
+				chunk, err := newTOCChunk(e, e.Size, dgst, chunkRange)
+				if err != nil {
+					return nil, err
+				}
+				entry.chunks = append(entry.chunks, chunk)
+			}
+			tl.files[p] = tarFile{
+				desc: ocispec.Descriptor{
+					MediaType: MediaTypeApplicationOctetStream,
+					Digest:    dgst,
+					Size:      e.Size,
+					Annotations: map[string]string{
+						ocispec.AnnotationTitle: p,
+					},
+				},
+				entry: entry,
+			}
+		case "chunk":
+			f, ok := tl.files[p]
+			if !ok || f.link != "" {
+				return nil, fmt.Errorf("chunk of unknown file %q", e.Name)
+			}
+			chunk, err := newTOCChunk(e, f.desc.Size, "", chunkRange)
+			if err != nil {
+				return nil, err
+			}
+			f.entry.chunks = append(f.entry.chunks, chunk)
+			tl.files[p] = f
+		case "symlink":
+			target := e.LinkName
+			if !path.IsAbs(target) {
+				target = path.Join(dir, target)
+			}
+			tl.files[p] = tarFile{link: cleanTarPath(target)}
+		case "hardlink":
+			tl.files[p] = tarFile{link: cleanTarPath(e.LinkName)}
+		case "dir":
+			tl.dirs = append(tl.dirs, p)
+		}
+	}
+
+	// Check that the chunks cover the whole files
+	for p, f := range tl.files {
+		if f.link != "" {
+			continue
+		}
+		var size int64
+		for _, c := range f.entry.chunks {
+			size += c.desc.Size
+		}
+		if size != f.desc.Size {
+			return nil, fmt.Errorf("the chunks of %q do not cover the file (%d != %d bytes)", p, size, f.desc.Size)
+		}
+	}
+	return tl, nil
+}
+
+// newTOCChunk returns the chunk of the TOC entry of a file of fileSize bytes.
+// fileDigest is the digest of the whole file, used when e is the only chunk of the file.
+func newTOCChunk(e tocEntry, fileSize int64, fileDigest digest.Digest, chunkRange func(tocEntry) (int64, int64, error)) (tocChunk, error) {
+	size := e.ChunkSize
+	if size == 0 {
+		// The last chunk has no size, as well as the file that is not chunked
+		size = fileSize - e.ChunkOffset
+	}
+	var dgst digest.Digest
+	switch {
+	case e.ChunkDigest != "":
+		var err error
+		dgst, err = digest.Parse(e.ChunkDigest)
+		if err != nil {
+			return tocChunk{}, fmt.Errorf("invalid chunk digest of %q: %w", e.Name, err)
+		}
+	case size == fileSize && fileDigest != "":
+		dgst = fileDigest
+	default:
+		return tocChunk{}, fmt.Errorf("missing chunk digest of %q", e.Name)
+	}
+	if size <= 0 {
+		return tocChunk{}, fmt.Errorf("invalid chunk size %d of %q", size, e.Name)
+	}
+	chunk := tocChunk{
+		desc: ocispec.Descriptor{Digest: dgst, Size: size},
+	}
+	if e.ChunkType == "zeros" {
+		chunk.zeros = true
+		return chunk, nil
+	}
+	var err error
+	chunk.offset, chunk.end, err = chunkRange(e)
+	return chunk, err
+}
+
+// fetchChunks fetches the chunks of the entry with ranged requests.
+// Each chunk is verified with its digest, and the whole file is verified with desc.
+func (m *Method) fetchChunks(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor, e tarEntry) (io.ReadCloser, *mirror, error) {
+	m.Statusf(uri, "Fetching %d chunks of %q from layer %s", len(e.chunks), e.name, e.layer.Digest)
+	if len(e.chunks) == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil, nil
+	}
+	// The chunks of a file are usually adjacent in the layer, and are read with a single ranged request
+	// that ends at the last chunk, without streaming the rest of the layer.
+	var end int64
+	for _, chunk := range e.chunks {
+		end = max(end, chunk.end)
+	}
+	if end > 0 {
+		ctx = ociutil.WithRangeEnd(ctx, end)
+	}
+	rc, mi, err := m.fetchBlob(ctx, uri, c, e.layer)
+	if err != nil {
+		return nil, mi, err
+	}
+	parts := make([]ocispec.Descriptor, len(e.chunks))
+	for i, chunk := range e.chunks {
+		parts[i] = chunk.desc
+	}
+	var i int
+	open := func(ocispec.Descriptor) (io.ReadCloser, error) {
+		chunk := e.chunks[i]
+		i++
+		if chunk.zeros {
+			return io.NopCloser(io.LimitReader(zeroReader{}, chunk.desc.Size)), nil
+		}
+		if err := ociutil.Seek(rc, chunk.offset); err != nil {
+			return nil, err
+		}
+		compressed := io.LimitReader(rc, chunk.end-chunk.offset)
+		dr, err := decompress(compressed, e.compression)
+		if err != nil {
+			return nil, err
+		}
+		closeChunk := func() error {
+			// Read up to the end of the chunk, so that the next chunk does not need another request
+			_, err := io.Copy(io.Discard, compressed)
+			return errors.Join(dr.Close(), err)
+		}
+		return &readCloser{Reader: io.LimitReader(dr, chunk.desc.Size), close: closeChunk}, nil
+	}
+	pr := &partsReader{
+		open:     open,
+		parts:    parts,
+		verifier: desc.Digest.Verifier(),
+		desc:     desc,
+	}
+	if err := pr.next(); err != nil {
+		rc.Close()
+		return nil, mi, err
+	}
+	return &readCloser{Reader: pr, close: func() error { return errors.Join(pr.Close(), rc.Close()) }}, mi, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
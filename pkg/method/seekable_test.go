@@ -0,0 +1,215 @@
+package method
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// seekableTestFiles are the files in the seekable test layers.
+// "pool/main/h/hello/hello_1.0_amd64.deb" is split into two chunks.
+var seekableTestFiles = map[string]string{
+	"dists/stable/InRelease":                "InRelease",
+	"pool/main/h/hello/hello_1.0_amd64.deb": "hello, chunked world",
+}
+
+// buildSeekableTestLayer builds a seekable layer with compress, which appends a compressed stream to buf.
+func buildSeekableTestLayer(t testing.TB, buf *bytes.Buffer, compress func(*bytes.Buffer, []byte), withEndOffset bool) []tocEntry {
+	// The first stream corresponds to the tar header of the first file, so the offsets of the files are not 0
+	compress(buf, []byte("header"))
+	var entries []tocEntry
+	// As in the real eStargz writers, the last chunk of a file has no chunkSize
+	addChunk := func(e tocEntry, content string, last bool) tocEntry {
+		e.Offset = int64(buf.Len())
+		if !last {
+			e.ChunkSize = int64(len(content))
+		}
+		e.ChunkDigest = digest.FromString(content).String()
+		compress(buf, []byte(content))
+		if withEndOffset {
+			e.EndOffset = int64(buf.Len())
+		}
+		return e
+	}
+	entries = append(entries, tocEntry{Name: "dists/", Type: "dir"})
+	entries = append(entries, tocEntry{Name: "dists/stable/", Type: "dir"})
+	content := seekableTestFiles["dists/stable/InRelease"]
+	entries = append(entries, addChunk(tocEntry{
+		Name:   "dists/stable/InRelease",
+		Type:   "reg",
+		Size:   int64(len(content)),
+		Digest: digest.FromString(content).String(),
+	}, content, true))
+	entries = append(entries, tocEntry{Name: "dists/latest", Type: "symlink", LinkName: "stable"})
+	content = seekableTestFiles["pool/main/h/hello/hello_1.0_amd64.deb"]
+	entries = append(entries, addChunk(tocEntry{
+		Name:   "pool/main/h/hello/hello_1.0_amd64.deb",
+		Type:   "reg",
+		Size:   int64(len(content)),
+		Digest: digest.FromString(content).String(),
+	}, content[:7], false))
+	entries = append(entries, addChunk(tocEntry{
+		Name:        "pool/main/h/hello/hello_1.0_amd64.deb",
+		Type:        "chunk",
+		ChunkOffset: 7,
+	}, content[7:], true))
+	return entries
+}
+
+func gzipMember(buf *bytes.Buffer, b []byte) {
+	gw := gzip.NewWriter(buf)
+	gw.Write(b)
+	gw.Close()
+}
+
+func buildStargzTestLayer(t testing.TB) ([]byte, map[string]string) {
+	var buf bytes.Buffer
+	entries := buildSeekableTestLayer(t, &buf, gzipMember, false)
+	tocJSON, err := json.Marshal(toc{Version: 1, Entries: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tocOffset := buf.Len()
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: stargzTOCTarName, Size: int64(len(tocJSON))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(tocJSON)
+	tw.Close()
+	gw.Close()
+
+	// Footer
+	gw, err = gzip.NewWriterLevel(&buf, gzip.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+	extra := []byte{'S', 'G', 0, 0}
+	binary.LittleEndian.PutUint16(extra[2:4], uint16(len(subfield)))
+	gw.Header.Extra = append(extra, subfield...)
+	gw.Close()
+	return buf.Bytes(), map[string]string{AnnotationStargzTOCDigest: digest.FromBytes(tocJSON).String()}
+}
+
+func buildZstdChunkedTestLayer(t testing.TB) ([]byte, map[string]string) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compress := func(buf *bytes.Buffer, b []byte) {
+		buf.Write(enc.EncodeAll(b, nil))
+	}
+	var buf bytes.Buffer
+	entries := buildSeekableTestLayer(t, &buf, compress, true)
+	tocJSON, err := json.Marshal(toc{Version: 1, Entries: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := enc.EncodeAll(tocJSON, nil)
+	offset := buf.Len()
+	buf.Write(compressed)
+	return buf.Bytes(), map[string]string{
+		AnnotationZstdChunkedManifestChecksum: digest.FromBytes(compressed).String(),
+		AnnotationZstdChunkedManifestPosition: fmt.Sprintf("%d:%d:%d:1", offset, len(compressed), len(tocJSON)),
+	}
+}
+
+func TestSeekableLayers(t *testing.T) {
+	for name, tc := range map[string]struct {
+		build     func(testing.TB) ([]byte, map[string]string)
+		mediaType string
+	}{
+		"eStargz":      {build: buildStargzTestLayer, mediaType: ocispec.MediaTypeImageLayerGzip},
+		"zstd:chunked": {build: buildZstdChunkedTestLayer, mediaType: ocispec.MediaTypeImageLayerZstd},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			f := make(memFetcher)
+			b, annotations := tc.build(t)
+			layer := f.add(tc.mediaType, b)
+			layer.Annotations = annotations
+			config := f.add(ocispec.MediaTypeImageConfig, []byte("{}"))
+			root := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Config:    config,
+				Layers:    []ocispec.Descriptor{layer},
+			})
+			fm, err := buildFileMap(ctx, f, root, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := map[string]string{
+				"dists/latest/InRelease": seekableTestFiles["dists/stable/InRelease"],
+			}
+			for title, content := range seekableTestFiles {
+				expected[title] = content
+			}
+			if len(fm.files) != len(expected) {
+				t.Fatalf("expected %d files, got %v", len(expected), fm.files)
+			}
+
+			m := New(io.Discard, nil)
+			c := &cacheByOCIRef{
+				mirrors:  []*mirror{{fetcher: f}},
+				rootDesc: root,
+				fileMap:  fm,
+			}
+			fetch := func(title string) ([]byte, error) {
+				desc, ok := fm.files[title]
+				if !ok {
+					t.Fatalf("%q is missing", title)
+				}
+				if e := fm.entries[desc.Digest]; e.chunks == nil {
+					t.Fatalf("%q is not served with ranged requests", title)
+				}
+				r, _, err := m.fetch(ctx, "oci://example.com/foo:latest/"+title, c, desc)
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return io.ReadAll(r)
+			}
+			for title, content := range expected {
+				b, err := fetch(title)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != content {
+					t.Fatalf("unexpected content of %q: %q", title, b)
+				}
+			}
+
+			// A tampered chunk is detected
+			const title = "pool/main/h/hello/hello_1.0_amd64.deb"
+			e := fm.entries[fm.files[title].Digest]
+			blob := f[layer.Digest]
+			tampered := bytes.Clone(blob)
+			var chunk bytes.Buffer
+			if tc.mediaType == ocispec.MediaTypeImageLayerGzip {
+				gzipMember(&chunk, []byte("HELLO, "))
+			} else {
+				enc, _ := zstd.NewWriter(nil)
+				chunk.Write(enc.EncodeAll([]byte("HELLO, "), nil))
+			}
+			if int64(chunk.Len()) != e.chunks[0].end-e.chunks[0].offset {
+				t.Skip("the tampered chunk has a different size")
+			}
+			copy(tampered[e.chunks[0].offset:], chunk.Bytes())
+			f[layer.Digest] = tampered
+			if _, err := fetch(title); err == nil {
+				t.Fatal("expected an error for the tampered chunk")
+			}
+		})
+	}
+}
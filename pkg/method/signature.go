@@ -0,0 +1,95 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/signature"
+)
+
+// Config items for the signature verification.
+const (
+	// ConfigCosignPublicKey is the list of the PEM-encoded cosign public key files.
+	// When this is set, the root digest has to be signed with one of the keys.
+	ConfigCosignPublicKey = "Acquire::oci::Cosign::PublicKey"
+
+	// ConfigCosignBundle is the list of the Sigstore bundle files stored on the local filesystem.
+	// These bundles are tried in addition to the signatures stored in the registry.
+	ConfigCosignBundle = "Acquire::oci::Cosign::Bundle"
+
+	// ConfigNotationConfigDir is the notation configuration directory, such as "/etc/notation".
+	// When this is set, the root digest has to be signed in accordance with the trust policy in the directory.
+	ConfigNotationConfigDir = "Acquire::oci::Notation::ConfigDir"
+
+	// ConfigAttestationPolicy is the attestation policy file.
+	// See signature.AttestationPolicy for the format.
+	ConfigAttestationPolicy = "Acquire::oci::Attestation::Policy"
+)
+
+// FailReasons for the signature verification.
+const (
+	FailReasonMissingAttestation         = "MissingAttestation"
+	FailReasonAttestationPolicyViolation = "AttestationPolicyViolation"
+)
+
+// signatureVerifiers returns the verifiers enabled in the config.
+func (m *Method) signatureVerifiers() ([]signature.Verifier, error) {
+	if m.verifiers != nil {
+		return m.verifiers, nil
+	}
+	verifiers := []signature.Verifier{}
+	if keys := m.config.Values(ConfigCosignPublicKey); len(keys) > 0 {
+		v, err := signature.NewCosignVerifier(keys, m.config.Values(ConfigCosignBundle))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", ConfigCosignPublicKey, err)
+		}
+		verifiers = append(verifiers, v)
+	}
+	if dir := m.config.Get(ConfigNotationConfigDir); dir != "" {
+		v, err := signature.NewNotationVerifier(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", ConfigNotationConfigDir, err)
+		}
+		verifiers = append(verifiers, v)
+	}
+	if f := m.config.Get(ConfigAttestationPolicy); f != "" {
+		v, err := signature.NewAttestationVerifier(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", ConfigAttestationPolicy, err)
+		}
+		verifiers = append(verifiers, v)
+	}
+	m.verifiers = verifiers
+	return verifiers, nil
+}
+
+// verifySignatures verifies the signatures of mi.rootDesc.
+// mi has to be resolved with resolveMirror.
+func (m *Method) verifySignatures(ctx context.Context, uri string, mi *mirror) error {
+	verifiers, err := m.signatureVerifiers()
+	if err != nil {
+		return err
+	}
+	t := signature.Target{
+		Ref:      mi.ref,
+		Desc:     mi.rootDesc,
+		Resolver: mi.resolver,
+		Fetcher:  mi.fetcher,
+		Warnf:    m.w.Warningf,
+	}
+	for _, v := range verifiers {
+		m.Statusf(uri, "Verifying the %s signature of %s@%s", v.Name(), mi.ref.Name(), mi.rootDesc.Digest)
+		if err := v.Verify(ctx, t); err != nil {
+			err = fmt.Errorf("%s verification failed: %w", v.Name(), err)
+			switch {
+			case errors.Is(err, signature.ErrAttestationMissing):
+				err = &failReasonError{reason: FailReasonMissingAttestation, err: err}
+			case errors.Is(err, signature.ErrAttestationPolicyViolation):
+				err = &failReasonError{reason: FailReasonAttestationPolicyViolation, err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
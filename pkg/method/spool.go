@@ -0,0 +1,97 @@
+package method
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxSpoolSize is the maximum total size of the files kept in the spool.
+// The files that do not fit are extracted from the layers again.
+const maxSpoolSize = 1 << 30 // 1 GiB
+
+// spool is an unlinked temporary file that keeps the files extracted from the tar layers
+// while building the file map, so that the layers are not downloaded again to serve the files.
+// The spool is removed by the kernel when the process exits.
+type spool struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newSpool(dir string) (*spool, error) {
+	f, err := os.CreateTemp(dir, "apt-transport-oci-spool-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &spool{f: f}, nil
+}
+
+// reserve reserves n bytes in the spool, and returns the offset.
+// ok is false if n bytes do not fit.
+// A nil spool fits nothing.
+//
+// The space is reserved even if the write fails, as the size is just the limit.
+func (sp *spool) reserve(n int64) (off int64, ok bool) {
+	if sp == nil {
+		return 0, false
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.size+n > maxSpoolSize {
+		return 0, false
+	}
+	off = sp.size
+	sp.size += n
+	return off, true
+}
+
+// spoolWriter writes to the space reserved in the spool.
+// The spool is just an optimization, so a write error (e.g., ENOSPC) does not fail the write;
+// the error is recorded in err, and the rest is discarded.
+type spoolWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// writer returns the writer of the n bytes reserved at off.
+func (sp *spool) writer(off, n int64) *spoolWriter {
+	return &spoolWriter{w: io.NewOffsetWriter(sp.f, off), n: n}
+}
+
+func (sw *spoolWriter) Write(p []byte) (int, error) {
+	if sw.err == nil {
+		if int64(len(p)) > sw.n {
+			sw.err = errors.New("larger than the reserved space")
+		} else {
+			_, sw.err = sw.w.Write(p)
+			sw.n -= int64(len(p))
+		}
+	}
+	return len(p), nil
+}
+
+// reader returns the reader of the n bytes at off.
+func (sp *spool) reader(off, n int64) io.ReadCloser {
+	return io.NopCloser(io.NewSectionReader(sp.f, off, n))
+}
+
+// tarSpool returns the spool, creating it on the first call.
+// nil is returned when the spool cannot be created.
+func (m *Method) tarSpool() *spool {
+	if m.spool == nil && !m.spoolFailed {
+		sp, err := newSpool("")
+		if err != nil {
+			m.w.Warningf("Failed to create the spool, the files will be extracted from the layers again: %v", err)
+			m.spoolFailed = true
+		}
+		m.spool = sp
+	}
+	return m.spool
+}
@@ -0,0 +1,259 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// A tagless source such as "oci://ghcr.io/foo/bar" serves multiple suites.
+// The tag is chosen from the "dists/<suite>/..." path component,
+// e.g., "oci://ghcr.io/foo/bar/dists/stable/InRelease" is fetched from "ghcr.io/foo/bar:stable".
+//
+// "pool/..." files are looked up in the file maps of the known suites:
+//   - the suites requested in this process, and
+//   - the suites that have the Release files in apt's lists directory ("Dir::State::lists").
+
+// suiteOf returns the suite of "dists/<suite>/...".
+func suiteOf(title string) (string, bool) {
+	rest, ok := strings.CutPrefix(title, "dists/")
+	if !ok {
+		return "", false
+	}
+	suite, _, ok := strings.Cut(rest, "/")
+	return suite, ok && suite != ""
+}
+
+// checkRepoConfig returns an error if the suite or the component of "dists/<suite>/<component>/..."
+// is not published in the repository config.
+// The files that are not under "dists/" are not checked.
+func checkRepoConfig(rc *repoconfig.Config, title string) error {
+	if rc == nil {
+		return nil
+	}
+	suite, ok := suiteOf(title)
+	if !ok {
+		return nil
+	}
+	if len(rc.Suites) == 0 {
+		return fmt.Errorf("suite %q not published in this artifact (the artifact is a flat repository)", suite)
+	}
+	if !rc.HasSuite(suite) {
+		return fmt.Errorf("suite %q not published in this artifact (published suites: %s)", suite, strings.Join(rc.Suites, " "))
+	}
+	rest := strings.TrimPrefix(title, "dists/"+suite+"/")
+	// Files like "dists/<suite>/InRelease" and "dists/<suite>/Contents-amd64.gz" are not under the components
+	if strings.Contains(rest, "/") && !strings.HasPrefix(rest, "by-hash/") && !rc.HasComponentPath(rest) {
+		return fmt.Errorf("component of %q not published in this artifact (published components: %s)", title, strings.Join(rc.Components, " "))
+	}
+	return nil
+}
+
+// withTag returns a copy of the tagless source with the tag.
+func (src *source) withTag(tag string) (*source, error) {
+	s := &source{
+		repoURI: src.repoURI,
+		mirrors: make([]*mirror, len(src.mirrors)),
+	}
+	for i, mi := range src.mirrors {
+		ref, err := refdocker.WithTag(mi.ref, tag)
+		if err != nil {
+			return nil, fmt.Errorf("suite %q cannot be used as a tag of %q: %w", tag, mi.ref, err)
+		}
+		s.mirrors[i] = &mirror{uri: mi.uri, ref: ref, plainHTTP: mi.plainHTTP}
+	}
+	return s, nil
+}
+
+// suiteSources returns the sources to look up title in.
+// A tagged source is returned as is.
+func (m *Method) suiteSources(src *source, title string) ([]*source, error) {
+	var nTagless int
+	for _, mi := range src.mirrors {
+		if refdocker.IsNameOnly(mi.ref) {
+			nTagless++
+		}
+	}
+	switch nTagless {
+	case 0:
+		return []*source{src}, nil
+	case len(src.mirrors):
+	default:
+		return nil, fmt.Errorf("tagless and tagged references cannot be mixed in %q", src.repoURI)
+	}
+
+	var suites []string
+	if suite, ok := suiteOf(title); ok {
+		suites = []string{suite}
+		if !slices.Contains(m.seenSuites[src.repoURI], suite) {
+			m.seenSuites[src.repoURI] = append(m.seenSuites[src.repoURI], suite)
+		}
+	} else if strings.HasPrefix(title, "pool/") {
+		suites = m.knownSuites(src.repoURI)
+		if len(suites) == 0 {
+			return nil, fmt.Errorf("no suite is known for the tagless source %q (Hint: run `apt-get update`)", src.repoURI)
+		}
+	} else {
+		return nil, fmt.Errorf("cannot determine the suite of %q in the tagless source %q", title, src.repoURI)
+	}
+
+	srcs := make([]*source, len(suites))
+	for i, suite := range suites {
+		s, err := src.withTag(suite)
+		if err != nil {
+			return nil, err
+		}
+		srcs[i] = s
+	}
+	return srcs, nil
+}
+
+// knownSuites returns the suites requested in this process, and the suites found in apt's lists directory.
+func (m *Method) knownSuites(repoURI string) []string {
+	suites := slices.Clone(m.seenSuites[repoURI])
+	for _, suite := range listsSuites(m.config.findDir("Dir::State::lists"), repoURI) {
+		if !slices.Contains(suites, suite) {
+			suites = append(suites, suite)
+		}
+	}
+	return suites
+}
+
+// listsSuites returns the suites that have the Release files of repoURI in apt's lists directory.
+func listsSuites(listsDir, repoURI string) []string {
+	entries, err := os.ReadDir(listsDir)
+	if err != nil {
+		return nil
+	}
+	prefix := uriToFileName(repoURI + "dists/")
+	var suites []string
+	for _, ent := range entries {
+		rest, ok := strings.CutPrefix(ent.Name(), prefix)
+		if !ok {
+			continue
+		}
+		// Suites with "/" are ambiguous, as "/" is replaced with "_"
+		suite, file, ok := strings.Cut(rest, "_")
+		if !ok || (file != "InRelease" && file != "Release") {
+			continue
+		}
+		if unquoted, err := url.PathUnescape(suite); err == nil && !slices.Contains(suites, unquoted) {
+			suites = append(suites, unquoted)
+		}
+	}
+	slices.Sort(suites)
+	return suites
+}
+
+// uriToFileName converts the URI to the file name in apt's lists directory, like apt's URItoFileName.
+// The scheme and the credentials are removed.
+func uriToFileName(uri string) string {
+	rest, ok := strings.CutPrefix(uri, mirrorListPrefix)
+	if !ok {
+		_, rest, _ = strings.Cut(uri, "://")
+		if host, p, ok := strings.Cut(rest, "/"); ok {
+			if _, h, ok := strings.Cut(host, "@"); ok {
+				rest = h + "/" + p
+			}
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case c <= 0x20 || c >= 0x7f || strings.IndexByte(`\|{}[]<>"^~_=!@#$%&*`, c) >= 0:
+			fmt.Fprintf(&b, "%%%02x", c)
+		case c == '/':
+			b.WriteByte('_')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// errFileNotFound is returned by lookupFile when none of the sources has the file,
+// and none of the sources has failed.
+var errFileNotFound = errors.New("file not found")
+
+// lookupFile looks up title in the file maps of srcs.
+// When multiple sources have the file, the one that matches expectedSHA256 is preferred.
+// On errors, the first mirror that has failed is returned too, for the "UsedMirror" field.
+func (m *Method) lookupFile(ctx context.Context, uri string, srcs []*source, title, expectedSHA256 string) (*cacheByOCIRef, ocispec.Descriptor, *mirror, error) {
+	var (
+		found     *cacheByOCIRef
+		foundDesc ocispec.Descriptor
+		failed    *mirror
+		errs      []error
+	)
+	for _, src := range srcs {
+		c, mi, err := m.doCacheStuff(ctx, uri, src)
+		if err != nil {
+			if len(srcs) == 1 {
+				return nil, ocispec.Descriptor{}, mi, err
+			}
+			m.Statusf(uri, "Skipping %q: %v", src, err)
+			if failed == nil {
+				failed = mi
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if err := m.generateFlatRepo(ctx, uri, c, title); err != nil {
+			delete(m.cacheByOCIRef, src.String())
+			return nil, ocispec.Descriptor{}, nil, err
+		}
+		expected := func(desc ocispec.Descriptor) bool {
+			return expectedSHA256 == "" || desc.Digest == digest.NewDigestFromEncoded(digest.SHA256, expectedSHA256)
+		}
+		desc, ok, err := c.lookup(title, expected)
+		if err != nil {
+			// Build the file map again on the next request
+			delete(m.cacheByOCIRef, src.String())
+			if len(srcs) == 1 {
+				return nil, ocispec.Descriptor{}, nil, err
+			}
+			m.Statusf(uri, "Skipping %q: %v", src, err)
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			if err := checkRepoConfig(c.config(), title); err != nil {
+				if len(srcs) == 1 {
+					return nil, ocispec.Descriptor{}, nil, err
+				}
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if expected(desc) {
+			return c, desc, nil, nil
+		}
+		if found == nil {
+			found, foundDesc = c, desc
+		}
+	}
+	if found != nil {
+		return found, foundDesc, nil, nil
+	}
+	srcStrs := make([]string, len(srcs))
+	for i, src := range srcs {
+		srcStrs[i] = src.String()
+	}
+	if len(errs) > 0 {
+		// Not errFileNotFound, as the file might be in the failed sources
+		err := fmt.Errorf("file not found in %q: %q", strings.Join(srcStrs, ","), title)
+		return nil, ocispec.Descriptor{}, failed, errors.Join(append([]error{err}, errs...)...)
+	}
+	err := fmt.Errorf("%w in %q: %q", errFileNotFound, strings.Join(srcStrs, ","), title)
+	return nil, ocispec.Descriptor{}, nil, err
+}
@@ -0,0 +1,111 @@
+package method
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/repoconfig"
+)
+
+func TestSuiteSources(t *testing.T) {
+	listsDir := t.TempDir()
+	for _, f := range []string{
+		"ghcr.io_foo_bar_dists_stable_InRelease",
+		"ghcr.io_foo_bar_dists_testing_Release",
+		"ghcr.io_foo_bar_dists_testing_main_binary-amd64_Packages",
+		"ghcr.io_foo_bar2_dists_nightly_InRelease",
+		"_etc_apt_apt-transport-oci_mirrors_example.list_dists_nightly_InRelease",
+	} {
+		if err := os.WriteFile(filepath.Join(listsDir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := listsSuites(listsDir, "oci://ghcr.io/foo/bar/"); !reflect.DeepEqual(got, []string{"stable", "testing"}) {
+		t.Fatalf("unexpected suites: %v", got)
+	}
+	if got := listsSuites(listsDir, "oci+mirror+file:/etc/apt/apt-transport-oci/mirrors/example.list/"); !reflect.DeepEqual(got, []string{"nightly"}) {
+		t.Fatalf("unexpected suites for the mirror list: %v", got)
+	}
+
+	m := New(nil, nil)
+	m.config = config{"dir::state::lists": {listsDir}}
+	src, _, err := parseOCIURIFields("oci://ghcr.io/foo/bar/dists/experimental/InRelease", "oci://ghcr.io/foo/bar/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs, err := m.suiteSources(src, "dists/experimental/InRelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srcs) != 1 || srcs[0].String() != "ghcr.io/foo/bar:experimental" {
+		t.Fatalf("unexpected sources: %v", srcs)
+	}
+
+	srcs, err = m.suiteSources(src, "pool/main/h/hello/hello_1.0_amd64.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range srcs {
+		got = append(got, s.String())
+	}
+	expected := []string{"ghcr.io/foo/bar:experimental", "ghcr.io/foo/bar:stable", "ghcr.io/foo/bar:testing"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if _, err := m.suiteSources(src, "README"); err == nil {
+		t.Fatal("expected an error for a file outside dists/ and pool/")
+	}
+}
+
+func TestFindDir(t *testing.T) {
+	c := config{}
+	if got := c.findDir("Dir::State::lists"); got != "/var/lib/apt/lists" {
+		t.Fatalf("unexpected default: %q", got)
+	}
+	c = config{"dir": {"/tmp/root/"}, "dir::state::lists": {"lists2/"}}
+	if got := c.findDir("Dir::State::lists"); got != "/tmp/root/var/lib/apt/lists2" {
+		t.Fatalf("unexpected dir: %q", got)
+	}
+}
+
+func TestCheckRepoConfig(t *testing.T) {
+	rc := &repoconfig.Config{
+		Suites:     []string{"bookworm", "stable"},
+		Components: []string{"main", "updates/main"},
+	}
+	for _, title := range []string{
+		"dists/stable/InRelease",
+		"dists/bookworm/main/binary-amd64/Packages.xz",
+		"dists/bookworm/updates/main/binary-amd64/Packages.xz",
+		"dists/bookworm/by-hash/SHA256/0123",
+		"pool/main/h/hello/hello_1.0_amd64.deb",
+	} {
+		if err := checkRepoConfig(rc, title); err != nil {
+			t.Errorf("%q: %v", title, err)
+		}
+	}
+	for _, title := range []string{
+		"dists/trixie/InRelease",
+		"dists/bookworm/contrib/binary-amd64/Packages.xz",
+	} {
+		if err := checkRepoConfig(rc, title); err == nil || !strings.Contains(err.Error(), "not published in this artifact") {
+			t.Errorf("%q: expected an error, got %v", title, err)
+		}
+	}
+	// A flat repository has no suite
+	flat := &repoconfig.Config{Architectures: []string{"amd64"}}
+	if err := checkRepoConfig(flat, "dists/stable/InRelease"); err == nil {
+		t.Error("expected an error for a suite of a flat repository")
+	}
+	if err := checkRepoConfig(flat, "Packages"); err != nil {
+		t.Errorf("expected no error for a file of a flat repository, got %v", err)
+	}
+	if err := checkRepoConfig(nil, "dists/trixie/InRelease"); err != nil {
+		t.Errorf("expected no error without the repository config, got %v", err)
+	}
+}
@@ -0,0 +1,389 @@
+package method
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The layers of a regular container image, such as the image built from
+// "FROM scratch" + "COPY repo/ /" with `docker build`, are tar archives without the title annotation.
+// The files in the tar layers are served with their paths, e.g., "dists/stable/InRelease".
+//
+// The layers are applied in order, with the whiteouts of the OCI image spec.
+// The symbolic links and the hard links are followed within the image.
+//
+// A directory pushed with `oras push IMAGE DIR` is a tar layer with the title annotation (DIR),
+// and with the AnnotationORASContentUnpack annotation.
+// The files under DIR in the layer are served with their paths relative to DIR.
+//
+// The files extracted while building the file map are kept in the spool,
+// so that the layers are not downloaded again to serve the files.
+// The files that do not fit in the spool, or that fail to be written to the spool, are extracted again.
+
+// AnnotationORASContentUnpack is the annotation of the directories pushed with ORAS.
+const AnnotationORASContentUnpack = "io.deis.oras.content.unpack"
+
+// Whiteout file names
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// maxLinks is the maximum number of the links followed for a path.
+const maxLinks = 32
+
+// tarLayerCompression returns the compression of a tar layer.
+// ok is false for the layers that are not tar archives, and for the encrypted layers.
+func tarLayerCompression(mediaType string) (compression string, ok bool) {
+	if !images.IsLayerType(mediaType) || strings.Contains(mediaType, "+encrypted") {
+		return "", false
+	}
+	compression, err := images.DiffCompression(context.Background(), mediaType)
+	if err != nil {
+		return "", false
+	}
+	return compression, true
+}
+
+// tarEntry is a regular file in a tar layer.
+type tarEntry struct {
+	layer ocispec.Descriptor
+	// index is the index of the tar header in the layer
+	index int
+	name  string
+
+	// spool has the content at offset, if not nil
+	spool  *spool
+	offset int64
+
+	// chunks is not nil for the files in the seekable layers. See seekable.go.
+	chunks      []tocChunk
+	compression string
+}
+
+// tarFile is a file in a tar layer.
+type tarFile struct {
+	// desc is the descriptor of a regular file
+	desc  ocispec.Descriptor
+	entry tarEntry
+	// link is the target path of a symbolic link or a hard link
+	link string
+}
+
+// tarLayer is the index of a tar layer.
+type tarLayer struct {
+	files map[string]tarFile
+	dirs  []string
+	// whiteouts are the paths removed from the lower layers
+	whiteouts []string
+	// opaques are the directories whose contents in the lower layers are removed
+	opaques []string
+}
+
+// cleanTarPath cleans the path of a tar header, e.g., "./dists/stable/" -> "dists/stable".
+// The root directory is "".
+func cleanTarPath(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+// readTarLayer reads the tar layer, and computes the digests of the regular files.
+// The regular files are kept in sp, as long as they fit. sp may be nil.
+// The layer is verified with its digest.
+func readTarLayer(ctx context.Context, fetcher remotes.Fetcher, layer ocispec.Descriptor, compression string, sp *spool) (*tarLayer, error) {
+	rc, err := fetcher.Fetch(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	verifier := layer.Digest.Verifier()
+	tee := io.TeeReader(rc, verifier)
+	dr, err := decompress(tee, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	tl := &tarLayer{
+		files: make(map[string]tarFile),
+	}
+	tr := tar.NewReader(dr)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		p := cleanTarPath(hdr.Name)
+		if p == "" {
+			continue
+		}
+		dir, base := path.Split(p)
+		if base == whiteoutOpaque {
+			tl.opaques = append(tl.opaques, strings.TrimSuffix(dir, "/"))
+			continue
+		}
+		if hidden, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			tl.whiteouts = append(tl.whiteouts, dir+hidden)
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			digester := digest.SHA256.Digester()
+			r := io.TeeReader(tr, digester.Hash())
+			entry := tarEntry{layer: layer, index: i, name: p}
+			var n int64
+			if off, ok := sp.reserve(hdr.Size); ok {
+				sw := sp.writer(off, hdr.Size)
+				if n, err = io.Copy(sw, r); err != nil {
+					return nil, err
+				}
+				// On spool errors, the file is extracted from the layer again
+				if sw.err == nil && n == hdr.Size {
+					entry.spool, entry.offset = sp, off
+				}
+			} else if n, err = io.Copy(io.Discard, r); err != nil {
+				return nil, err
+			}
+			tl.files[p] = tarFile{
+				desc: ocispec.Descriptor{
+					MediaType: MediaTypeApplicationOctetStream,
+					Digest:    digester.Digest(),
+					Size:      n,
+					Annotations: map[string]string{
+						ocispec.AnnotationTitle: p,
+					},
+				},
+				entry: entry,
+			}
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(dir, target)
+			}
+			tl.files[p] = tarFile{link: cleanTarPath(target)}
+		case tar.TypeLink:
+			tl.files[p] = tarFile{link: cleanTarPath(hdr.Linkname)}
+		case tar.TypeDir:
+			tl.dirs = append(tl.dirs, p)
+		}
+	}
+	// Read the rest of the blob, such as the padding, to verify the digest
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, err
+	}
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("digest mismatch of layer %s", layer.Digest)
+	}
+	return tl, nil
+}
+
+// sub returns the tar layer re-rooted at the directory dir.
+// The paths outside dir are dropped.
+func (tl *tarLayer) sub(dir string) *tarLayer {
+	if dir == "." || dir == "/" {
+		return tl
+	}
+	rel := func(p string) (string, bool) {
+		return strings.CutPrefix(p, dir+"/")
+	}
+	s := &tarLayer{
+		files: make(map[string]tarFile),
+	}
+	for p, f := range tl.files {
+		r, ok := rel(p)
+		if !ok {
+			continue
+		}
+		if f.link != "" {
+			if f.link, ok = rel(f.link); !ok {
+				continue
+			}
+		}
+		s.files[r] = f
+	}
+	for _, p := range tl.dirs {
+		if r, ok := rel(p); ok {
+			s.dirs = append(s.dirs, r)
+		}
+	}
+	for _, p := range tl.whiteouts {
+		if r, ok := rel(p); ok {
+			s.whiteouts = append(s.whiteouts, r)
+		}
+	}
+	for _, p := range tl.opaques {
+		if p == dir {
+			s.opaques = append(s.opaques, "")
+		} else if r, ok := rel(p); ok {
+			s.opaques = append(s.opaques, r)
+		}
+	}
+	return s
+}
+
+// rootfs is the filesystem of a manifest, built by applying the layers in order.
+type rootfs struct {
+	files map[string]ocispec.Descriptor
+	links map[string]string
+}
+
+func newRootfs() *rootfs {
+	return &rootfs{
+		files: make(map[string]ocispec.Descriptor),
+		links: make(map[string]string),
+	}
+}
+
+// remove removes p and the paths under p.
+func (fs *rootfs) remove(p string) {
+	delete(fs.files, p)
+	delete(fs.links, p)
+	fs.removeUnder(p)
+}
+
+// removeUnder removes the paths under the directory dir.
+// The root directory is "".
+func (fs *rootfs) removeUnder(dir string) {
+	under := func(p string) bool {
+		return dir == "" || strings.HasPrefix(p, dir+"/")
+	}
+	maps.DeleteFunc(fs.files, func(p string, _ ocispec.Descriptor) bool { return under(p) })
+	maps.DeleteFunc(fs.links, func(p string, _ string) bool { return under(p) })
+}
+
+// add adds a file that is not in a tar layer.
+func (fs *rootfs) add(p string, desc ocispec.Descriptor) {
+	fs.remove(p)
+	fs.files[p] = desc
+}
+
+// applyTarLayer applies the tar layer, and records the entries of its regular files.
+func (fs *rootfs) applyTarLayer(tl *tarLayer, entries map[digest.Digest]tarEntry) {
+	for _, dir := range tl.opaques {
+		fs.removeUnder(dir)
+	}
+	for _, p := range tl.whiteouts {
+		fs.remove(p)
+	}
+	for _, p := range tl.dirs {
+		// A directory replaces a file
+		delete(fs.files, p)
+		delete(fs.links, p)
+	}
+	for p, f := range tl.files {
+		// A file replaces a directory
+		fs.remove(p)
+		if f.link != "" {
+			fs.links[p] = f.link
+			continue
+		}
+		fs.files[p] = f.desc
+		entries[f.desc.Digest] = f.entry
+	}
+}
+
+// resolveLink follows the links at p.
+func (fs *rootfs) resolveLink(p string) (string, bool) {
+	for range maxLinks {
+		target, ok := fs.links[p]
+		if !ok {
+			return p, true
+		}
+		p = target
+	}
+	return "", false
+}
+
+// resolve returns the files, with the links resolved.
+// The files under a link to a directory are also mapped under the link.
+func (fs *rootfs) resolve() map[string]ocispec.Descriptor {
+	files := maps.Clone(fs.files)
+	for p := range fs.links {
+		target, ok := fs.resolveLink(p)
+		if !ok {
+			continue
+		}
+		if desc, ok := fs.files[target]; ok {
+			files[p] = desc
+			continue
+		}
+		for f, desc := range fs.files {
+			if rest, ok := strings.CutPrefix(f, target+"/"); ok {
+				if _, exists := files[p+"/"+rest]; !exists {
+					files[p+"/"+rest] = desc
+				}
+			}
+		}
+	}
+	return files
+}
+
+// fetchTarEntry fetches the tar layer of the entry, and returns the reader of the entry.
+// The content is verified with desc by the caller.
+func (m *Method) fetchTarEntry(ctx context.Context, uri string, c *cacheByOCIRef, desc ocispec.Descriptor, e tarEntry) (io.ReadCloser, *mirror, error) {
+	if e.spool != nil {
+		m.Statusf(uri, "Reading %q of layer %s from the spool", e.name, e.layer.Digest)
+		return e.spool.reader(e.offset, desc.Size), nil, nil
+	}
+	if e.chunks != nil {
+		return m.fetchChunks(ctx, uri, c, desc, e)
+	}
+	compression, ok := tarLayerCompression(e.layer.MediaType)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected media type %q of layer %s", e.layer.MediaType, e.layer.Digest)
+	}
+	m.Statusf(uri, "Fetching %q from layer %s", e.name, e.layer.Digest)
+	rc, mi, err := m.fetchBlob(ctx, uri, c, e.layer)
+	if err != nil {
+		return nil, mi, err
+	}
+	dr, err := decompress(rc, compression)
+	if err != nil {
+		rc.Close()
+		return nil, mi, err
+	}
+	closeAll := func() error {
+		return errors.Join(dr.Close(), rc.Close())
+	}
+	tr := tar.NewReader(dr)
+	for i := 0; i <= e.index; i++ {
+		hdr, err := tr.Next()
+		if err != nil {
+			closeAll()
+			return nil, mi, fmt.Errorf("failed to find %q in layer %s: %w", e.name, e.layer.Digest, err)
+		}
+		if i == e.index && (cleanTarPath(hdr.Name) != e.name || hdr.Size != desc.Size) {
+			closeAll()
+			return nil, mi, fmt.Errorf("unexpected entry %q in layer %s, expected %q", hdr.Name, e.layer.Digest, e.name)
+		}
+	}
+	return &readCloser{Reader: tr, close: closeAll}, mi, nil
+}
+
+// readCloser is an io.ReadCloser with a custom close function.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc *readCloser) Close() error {
+	if rc.close == nil {
+		return nil
+	}
+	err := rc.close()
+	rc.close = nil
+	return err
+}
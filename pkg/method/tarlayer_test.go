@@ -0,0 +1,283 @@
+package method
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// memFetcher is a remotes.Fetcher for the blobs in memory.
+// The blobs are seekable, like the blobs fetched from the registries.
+type memFetcher map[digest.Digest][]byte
+
+type memBlob struct {
+	*bytes.Reader
+}
+
+func (memBlob) Close() error {
+	return nil
+}
+
+func (f memFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	b, ok := f[desc.Digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	return memBlob{bytes.NewReader(b)}, nil
+}
+
+func (f memFetcher) add(mediaType string, b []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	f[desc.Digest] = b
+	return desc
+}
+
+func (f memFetcher) addJSON(t testing.TB, mediaType string, v any) ocispec.Descriptor {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.add(mediaType, b)
+}
+
+type tarTestEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+func gzipTar(t testing.TB, entries []tarTestEntry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Size:     int64(len(e.content)),
+			Mode:     0o644,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildFileMapTarLayers(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	lower := f.add(ocispec.MediaTypeImageLayerGzip, gzipTar(t, []tarTestEntry{
+		{name: "dists/", typeflag: tar.TypeDir},
+		{name: "dists/bookworm/", typeflag: tar.TypeDir},
+		{name: "dists/bookworm/InRelease", typeflag: tar.TypeReg, content: "old InRelease"},
+		{name: "dists/bookworm/Release", typeflag: tar.TypeReg, content: "Release"},
+		{name: "pool/main/h/hello/hello_1.0_amd64.deb", typeflag: tar.TypeReg, content: "hello 1.0"},
+		{name: "pool/main/o/old/old_1.0_amd64.deb", typeflag: tar.TypeReg, content: "old"},
+	}))
+	upper := f.add(ocispec.MediaTypeImageLayerGzip, gzipTar(t, []tarTestEntry{
+		{name: "./dists/bookworm/InRelease", typeflag: tar.TypeReg, content: "new InRelease"},
+		{name: "./dists/stable", typeflag: tar.TypeSymlink, linkname: "bookworm"},
+		{name: "./pool/main/o/.wh.old", typeflag: tar.TypeReg},
+		{name: "./pool/main/h/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "./pool/main/h/hello/hello_2.0_amd64.deb", typeflag: tar.TypeReg, content: "hello 2.0"},
+		{name: "./pool/main/h/hello/hello.deb", typeflag: tar.TypeLink, linkname: "pool/main/h/hello/hello_2.0_amd64.deb"},
+	}))
+	config := f.add(ocispec.MediaTypeImageConfig, []byte("{}"))
+	root := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{lower, upper},
+	})
+
+	fm, err := buildFileMap(ctx, f, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"dists/bookworm/InRelease":              "new InRelease",
+		"dists/bookworm/Release":                "Release",
+		"dists/stable/InRelease":                "new InRelease",
+		"dists/stable/Release":                  "Release",
+		"pool/main/h/hello/hello_2.0_amd64.deb": "hello 2.0",
+		"pool/main/h/hello/hello.deb":           "hello 2.0",
+	}
+	if len(fm.files) != len(expected) {
+		t.Fatalf("expected %d files, got %v", len(expected), fm.files)
+	}
+	for title, content := range expected {
+		desc, ok := fm.files[title]
+		if !ok {
+			t.Fatalf("%q is missing", title)
+		}
+		if desc.Digest != digest.FromString(content) || desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected descriptor of %q: %+v", title, desc)
+		}
+		e, ok := fm.entries[desc.Digest]
+		if !ok {
+			t.Fatalf("%q has no tar entry", title)
+		}
+		if blobs := fm.blobs(desc); len(blobs) != 1 || blobs[0] != e.layer.Digest {
+			t.Fatalf("unexpected blobs of %q: %v", title, blobs)
+		}
+	}
+
+	m := New(io.Discard, nil)
+	c := &cacheByOCIRef{
+		mirrors:  []*mirror{{fetcher: f}},
+		rootDesc: root,
+		fileMap:  fm,
+	}
+	desc := fm.files["dists/stable/InRelease"]
+	r, _, err := m.fetch(ctx, "oci://example.com/foo:latest/dists/stable/InRelease", c, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new InRelease" {
+		t.Fatalf("unexpected content: %q", b)
+	}
+}
+
+func TestBuildFileMapORASDirectory(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	dir := f.add(ocispec.MediaTypeImageLayerGzip, gzipTar(t, []tarTestEntry{
+		{name: "aptrepo/", typeflag: tar.TypeDir},
+		{name: "aptrepo/dists/bookworm/InRelease", typeflag: tar.TypeReg, content: "InRelease"},
+		{name: "aptrepo/dists/stable", typeflag: tar.TypeSymlink, linkname: "bookworm"},
+		{name: "aptrepo/pool/main/h/hello/hello_1.0_amd64.deb", typeflag: tar.TypeReg, content: "hello 1.0"},
+	}))
+	dir.Annotations = map[string]string{
+		ocispec.AnnotationTitle:     "aptrepo",
+		AnnotationORASContentUnpack: "true",
+	}
+	config := f.add("application/vnd.oci.empty.v1+json", []byte("{}"))
+	root := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{dir},
+	})
+
+	sp, err := newSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm, err := buildFileMap(ctx, f, root, sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"dists/bookworm/InRelease":              "InRelease",
+		"dists/stable/InRelease":                "InRelease",
+		"pool/main/h/hello/hello_1.0_amd64.deb": "hello 1.0",
+	}
+	if len(fm.files) != len(expected) {
+		t.Fatalf("expected %d files, got %v", len(expected), fm.files)
+	}
+
+	// The files are served from the spool, without fetching the layer again
+	delete(f, dir.Digest)
+	m := New(io.Discard, nil)
+	c := &cacheByOCIRef{
+		mirrors:  []*mirror{{fetcher: f}},
+		rootDesc: root,
+		fileMap:  fm,
+	}
+	for title, content := range expected {
+		r, _, err := m.fetch(ctx, "oci://example.com/foo:latest/"+title, c, fm.files[title])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("unexpected content of %q: %q", title, b)
+		}
+	}
+}
+
+func TestBuildFileMapSpoolError(t *testing.T) {
+	ctx := context.Background()
+	f := make(memFetcher)
+	layer := f.add(ocispec.MediaTypeImageLayerGzip, gzipTar(t, []tarTestEntry{
+		{name: "dists/bookworm/InRelease", typeflag: tar.TypeReg, content: "InRelease"},
+	}))
+	config := f.add("application/vnd.oci.empty.v1+json", []byte("{}"))
+	root := f.addJSON(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+
+	sp, err := newSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fail the writes to the spool
+	if err := sp.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fm, err := buildFileMap(ctx, f, root, sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, ok := fm.files["dists/bookworm/InRelease"]
+	if !ok {
+		t.Fatalf("InRelease is missing: %v", fm.files)
+	}
+	if e := fm.entries[desc.Digest]; e.spool != nil {
+		t.Fatalf("expected the entry not to be spooled: %+v", e)
+	}
+
+	// The file is extracted from the layer again
+	m := New(io.Discard, nil)
+	c := &cacheByOCIRef{
+		mirrors:  []*mirror{{fetcher: f}},
+		rootDesc: root,
+		fileMap:  fm,
+	}
+	r, _, err := m.fetch(ctx, "oci://example.com/foo:latest/dists/bookworm/InRelease", c, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "InRelease" {
+		t.Fatalf("unexpected content: %q", b)
+	}
+}
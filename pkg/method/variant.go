@@ -0,0 +1,104 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// apt requests the compressed variants of the index files in the order of its preference,
+// e.g., "Packages.xz", then "Packages.gz", then "Packages", as long as the Release file lists them.
+//
+// When the uncompressed variant (e.g., "Packages") is requested but is not in the image,
+// it is synthesized by decompressing a compressed variant (e.g., "Packages.gz").
+// When the gzip variant (e.g., "Packages.gz") is requested but is not in the image,
+// it is synthesized by compressing another variant with the deterministic encoder (see compressGzip).
+// The synthesized variant is verified with the Expected-SHA256 field of the request,
+// which is taken from the Release file, so the variants that are not listed in the Release file are never synthesized.
+//
+// The synthesized gzip variant matches only when the publisher used the same encoder,
+// as the output of the encoders differs (e.g., Go's compress/gzip and `gzip -9n`).
+// Otherwise the verification fails, and apt falls back to the next variant.
+// The other compressed variants are not synthesized, as there are no deterministic encoders for them.
+
+// indexVariantExts are the file name extensions of the variants, in the order of the preference as the source.
+var indexVariantExts = []string{"", ".zst", ".gz", ".xz"}
+
+// indexVariantCompressions maps the file name extensions to the compressions.
+var indexVariantCompressions = map[string]string{
+	"":     compressionNone,
+	".gz":  compressionGzip,
+	".xz":  compressionXz,
+	".zst": compressionZstd,
+}
+
+// indexVariant is the variant synthesized from the file src.
+type indexVariant struct {
+	src ocispec.Descriptor
+	// srcCompression is the compression of the content of src (after decompressing the layer, for the compressed layers)
+	srcCompression string
+	// compression is the compression of the variant, compressionNone or compressionGzip
+	compression string
+}
+
+// isCompressedIndexVariant returns true if the title has the file name extension of a compression.
+func isCompressedIndexVariant(title string) bool {
+	i := strings.LastIndex(title, ".")
+	if i <= strings.LastIndex(title, "/") {
+		return false
+	}
+	switch title[i:] {
+	case ".gz", ".zst", ".xz", ".bz2", ".lzma", ".lz4":
+		return true
+	default:
+		return false
+	}
+}
+
+// lookupVariant looks up another variant of the file title, to synthesize title from it.
+func (m *Method) lookupVariant(ctx context.Context, uri string, srcs []*source, title, expectedSHA256 string) (*cacheByOCIRef, *indexVariant, error) {
+	if expectedSHA256 == "" {
+		return nil, nil, errors.New("no Expected-SHA256 to verify the synthesized variant")
+	}
+	base, ext := title, ""
+	if isCompressedIndexVariant(title) {
+		if !strings.HasSuffix(title, ".gz") {
+			return nil, nil, fmt.Errorf("compressed variant %q is not synthesized", title)
+		}
+		base, ext = strings.TrimSuffix(title, ".gz"), ".gz"
+	}
+	var errs []error
+	for _, srcExt := range indexVariantExts {
+		if srcExt == ext {
+			continue
+		}
+		c, desc, _, err := m.lookupFile(ctx, uri, srcs, base+srcExt, "")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		v := &indexVariant{
+			src:            desc,
+			srcCompression: indexVariantCompressions[srcExt],
+			compression:    indexVariantCompressions[ext],
+		}
+		return c, v, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// synthesize returns the reader of the variant, from the reader of the content of v.src.
+func (v *indexVariant) synthesize(r io.Reader) (io.ReadCloser, error) {
+	dr, err := decompress(r, v.srcCompression)
+	if err != nil || v.compression == compressionNone {
+		return dr, err
+	}
+	cr := compressGzip(dr)
+	return &readCloser{Reader: cr, close: func() error {
+		return errors.Join(cr.Close(), dr.Close())
+	}}, nil
+}
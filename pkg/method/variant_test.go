@@ -0,0 +1,133 @@
+package method
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	refdocker "github.com/distribution/reference"
+	"github.com/klauspost/compress/zstd"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIsCompressedIndexVariant(t *testing.T) {
+	testCases := []struct {
+		title    string
+		expected bool
+	}{
+		{title: "dists/stable/main/binary-amd64/Packages"},
+		{title: "dists/stable/main/binary-amd64/Packages.gz", expected: true},
+		{title: "dists/stable/main/binary-amd64/Packages.zst", expected: true},
+		{title: "dists/stable/main/binary-amd64/Packages.xz", expected: true},
+		{title: "dists/stable.gz/main/Contents-amd64"},
+	}
+	for _, tc := range testCases {
+		if got := isCompressedIndexVariant(tc.title); got != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.title, tc.expected, got)
+		}
+	}
+}
+
+func readVariant(t testing.TB, v *indexVariant, b []byte) []byte {
+	r, err := v.synthesize(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestIndexVariantSynthesize(t *testing.T) {
+	packages := bytes.Repeat([]byte("Package: hello\nVersion: 1.0\n\n"), 100)
+	// Packages.gz as published with Go's compress/gzip, with the best compression and an empty header
+	var gz bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.Write(packages); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(packages); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packages from Packages.gz
+	if got := readVariant(t, &indexVariant{srcCompression: compressionGzip}, gz.Bytes()); !bytes.Equal(got, packages) {
+		t.Fatal("unexpected content of the decompressed variant")
+	}
+	// Packages.gz from Packages and from Packages.zst reproduces the published Packages.gz
+	for _, tc := range []struct {
+		srcCompression string
+		src            []byte
+	}{
+		{srcCompression: compressionNone, src: packages},
+		{srcCompression: compressionZstd, src: zst.Bytes()},
+	} {
+		got := readVariant(t, &indexVariant{srcCompression: tc.srcCompression, compression: compressionGzip}, tc.src)
+		if !bytes.Equal(got, gz.Bytes()) {
+			t.Fatalf("%q: the synthesized gzip variant does not match", tc.srcCompression)
+		}
+	}
+}
+
+func TestLookupVariant(t *testing.T) {
+	ctx := context.Background()
+	ref, err := refdocker.ParseNormalizedNamed("example.com/foo:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &source{mirrors: []*mirror{{ref: ref}}}
+	fm := newFileMap()
+	const title = "dists/stable/main/binary-amd64/Packages"
+	fm.files[title+".gz"] = ocispec.Descriptor{Annotations: map[string]string{ocispec.AnnotationTitle: title + ".gz"}}
+	m := New(io.Discard, nil)
+	m.cacheByOCIRef[src.String()] = &cacheByOCIRef{fileMap: fm}
+	const uri = "oci://example.com/foo:latest/" + title
+
+	if _, _, _, err := m.lookupFile(ctx, uri, []*source{src}, title, ""); !errors.Is(err, errFileNotFound) {
+		t.Fatalf("expected errFileNotFound, got %v", err)
+	}
+	_, v, err := m.lookupVariant(ctx, uri, []*source{src}, title, "0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.src.Annotations[ocispec.AnnotationTitle] != title+".gz" || v.srcCompression != compressionGzip {
+		t.Fatalf("unexpected variant %+v", v)
+	}
+	// The compressed variants other than gzip are not synthesized
+	if _, _, err := m.lookupVariant(ctx, uri, []*source{src}, title+".zst", "0000"); err == nil {
+		t.Fatal("expected an error for a zstd variant")
+	}
+	// The gzip variant is not synthesized from another gzip variant
+	if _, _, err := m.lookupVariant(ctx, uri, []*source{src}, title+".gz", "0000"); err == nil {
+		t.Fatal("expected an error for a gzip variant without another variant")
+	}
+	// The gzip variant is synthesized from the uncompressed variant
+	fm.files[title] = ocispec.Descriptor{Annotations: map[string]string{ocispec.AnnotationTitle: title}}
+	_, v, err = m.lookupVariant(ctx, uri, []*source{src}, title+".gz", "0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.src.Annotations[ocispec.AnnotationTitle] != title || v.srcCompression != compressionNone || v.compression != compressionGzip {
+		t.Fatalf("unexpected variant %+v", v)
+	}
+}
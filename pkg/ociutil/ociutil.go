@@ -0,0 +1,95 @@
+// Package ociutil provides utilities for OCI content.
+package ociutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MaxBlobSize is the maximum size of a blob that can be read into memory with ReadBlob.
+const MaxBlobSize = 16 * 1024 * 1024
+
+// ReadBlob reads the blob of desc into memory, and verifies the digest.
+// The blob must not be larger than MaxBlobSize.
+func ReadBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > MaxBlobSize {
+		return nil, fmt.Errorf("blob %s is too large (%d bytes)", desc.Digest, desc.Size)
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %q: %w", desc.Digest, err)
+	}
+	r, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, MaxBlobSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("expected size of %s to be %d, got %d", desc.Digest, desc.Size, len(b))
+	}
+	if dig := desc.Digest.Algorithm().FromBytes(b); dig != desc.Digest {
+		return nil, fmt.Errorf("expected digest %s, got %s", desc.Digest, dig)
+	}
+	return b, nil
+}
+
+// Seek seeks r, which is returned by a fetcher, to off.
+// The fetchers of the registries send a ranged request on the next read.
+func Seek(r io.Reader, off int64) error {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return errors.New("the fetcher does not support ranged requests")
+	}
+	_, err := s.Seek(off, io.SeekStart)
+	return err
+}
+
+type rangeEndKey struct{}
+
+// WithRangeEnd returns a context that bounds the ranged requests of the fetchers to end (exclusive).
+//
+// The fetchers of containerd send an open-ended ranged request ("Range: bytes=off-"),
+// and the registry would stream the rest of the blob, which may be gigabytes.
+// The HTTP transports of dockerconfigresolver rewrite the request to "Range: bytes=off-(end-1)".
+func WithRangeEnd(ctx context.Context, end int64) context.Context {
+	return context.WithValue(ctx, rangeEndKey{}, end)
+}
+
+// RangeEnd returns the end set with WithRangeEnd.
+func RangeEnd(ctx context.Context) (int64, bool) {
+	end, ok := ctx.Value(rangeEndKey{}).(int64)
+	return end, ok
+}
+
+// ReadRange reads n bytes at off of the blob desc, with a ranged request bounded with WithRangeEnd.
+// n must not be larger than MaxBlobSize.
+// The content is not verified, as the digest covers the whole blob.
+func ReadRange(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, off, n int64) ([]byte, error) {
+	if n < 0 || n > MaxBlobSize {
+		return nil, fmt.Errorf("invalid range length %d of blob %s", n, desc.Digest)
+	}
+	if off < 0 || off+n > desc.Size {
+		return nil, fmt.Errorf("range %d+%d is out of blob %s (%d bytes)", off, n, desc.Digest, desc.Size)
+	}
+	r, err := fetcher.Fetch(WithRangeEnd(ctx, off+n), desc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := Seek(r, off); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,122 @@
+// Package repoconfig implements the metadata of apt repositories in the config blobs of the manifests.
+package repoconfig
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ArtifactType is the artifact type of the apt repositories.
+const ArtifactType = "application/vnd.debian.apt.repository.v1"
+
+// MediaType is the media type of the config blob that has Config.
+const MediaType = "application/vnd.debian.apt.repository.config.v1+json"
+
+// Config is the metadata of an apt repository, e.g.,
+//
+//	{
+//	  "suites": ["bookworm", "stable"],
+//	  "components": ["main"],
+//	  "architectures": ["amd64", "arm64"],
+//	  "origin": "Example",
+//	  "label": "Example",
+//	  "signedBy": "0123456789ABCDEF0123456789ABCDEF01234567"
+//	}
+//
+// An empty Suites means a flat repository ("./").
+type Config struct {
+	Suites        []string `json:"suites,omitempty"`
+	Components    []string `json:"components,omitempty"`
+	Architectures []string `json:"architectures,omitempty"`
+	Origin        string   `json:"origin,omitempty"`
+	Label         string   `json:"label,omitempty"`
+	// SignedBy is the recommended fingerprint of the OpenPGP key for the "Signed-By" option
+	SignedBy string `json:"signedBy,omitempty"`
+}
+
+// Parse parses the config blob.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse the repository config: %w", err)
+	}
+	for _, s := range slices.Concat(c.Suites, c.Components, c.Architectures) {
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			return nil, fmt.Errorf("invalid name %q in the repository config", s)
+		}
+	}
+	// The components may have slashes, e.g., "updates/main"
+	for _, s := range slices.Concat(c.Suites, c.Architectures) {
+		if strings.Contains(s, "/") {
+			return nil, fmt.Errorf("invalid name %q in the repository config", s)
+		}
+	}
+	return &c, nil
+}
+
+// Merge merges other into c, for the indexes that have multiple manifests.
+// The lists are merged, and the strings of c take precedence.
+func (c *Config) Merge(other *Config) {
+	merge := func(a, b []string) []string {
+		for _, s := range b {
+			if !slices.Contains(a, s) {
+				a = append(a, s)
+			}
+		}
+		return a
+	}
+	c.Suites = merge(c.Suites, other.Suites)
+	c.Components = merge(c.Components, other.Components)
+	c.Architectures = merge(c.Architectures, other.Architectures)
+	c.Origin = cmp.Or(c.Origin, other.Origin)
+	c.Label = cmp.Or(c.Label, other.Label)
+	c.SignedBy = cmp.Or(c.SignedBy, other.SignedBy)
+}
+
+// HasSuite returns true if the suite is published.
+// No suite is published when Suites is empty, as the repository is a flat repository.
+func (c *Config) HasSuite(suite string) bool {
+	return slices.Contains(c.Suites, suite)
+}
+
+// HasComponentPath returns true if p ("<component>/...") is under a published component.
+// All the components are published when Components is empty.
+func (c *Config) HasComponentPath(p string) bool {
+	if len(c.Components) == 0 {
+		return true
+	}
+	for _, component := range c.Components {
+		if strings.HasPrefix(p, component+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns the deb822-style sources entry ("/etc/apt/sources.list.d/*.sources") for the URI,
+// e.g., "oci://ghcr.io/foo/bar".
+// The component defaults to "main".
+func (c *Config) Sources(uri string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Types: deb\nURIs: %s\n", uri)
+	if len(c.Suites) == 0 {
+		sb.WriteString("Suites: ./\n")
+	} else {
+		fmt.Fprintf(&sb, "Suites: %s\n", strings.Join(c.Suites, " "))
+		components := c.Components
+		if len(components) == 0 {
+			components = []string{"main"}
+		}
+		fmt.Fprintf(&sb, "Components: %s\n", strings.Join(components, " "))
+	}
+	if len(c.Architectures) > 0 {
+		fmt.Fprintf(&sb, "Architectures: %s\n", strings.Join(c.Architectures, " "))
+	}
+	if c.SignedBy != "" {
+		fmt.Fprintf(&sb, "Signed-By: %s\n", c.SignedBy)
+	}
+	return sb.String()
+}
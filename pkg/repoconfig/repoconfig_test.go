@@ -0,0 +1,62 @@
+package repoconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	c := &Config{Suites: []string{"stable"}, Architectures: []string{"amd64"}, Origin: "Example"}
+	c.Merge(&Config{Suites: []string{"stable"}, Architectures: []string{"arm64"}, Origin: "Other", SignedBy: "0123"})
+	expected := &Config{
+		Suites:        []string{"stable"},
+		Architectures: []string{"amd64", "arm64"},
+		Origin:        "Example",
+		SignedBy:      "0123",
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, c)
+	}
+
+	const expectedSources = `Types: deb
+URIs: oci://ghcr.io/foo/bar
+Suites: stable
+Components: main
+Architectures: amd64 arm64
+Signed-By: 0123
+`
+	if s := expected.Sources("oci://ghcr.io/foo/bar"); s != expectedSources {
+		t.Fatalf("unexpected sources:\n%s", s)
+	}
+}
+
+func TestFlatRepository(t *testing.T) {
+	c := &Config{Architectures: []string{"amd64"}}
+	if c.HasSuite("stable") {
+		t.Fatal("expected no suite to be published in a flat repository")
+	}
+	const expectedSources = `Types: deb
+URIs: oci://ghcr.io/foo/debs
+Suites: ./
+Architectures: amd64
+`
+	if s := c.Sources("oci://ghcr.io/foo/debs"); s != expectedSources {
+		t.Fatalf("unexpected sources:\n%s", s)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte(`{"suites": ["stable"], "components": ["updates/main"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`{"suites": ["stable/updates"]}`,
+		`{"architectures": ["amd64 arm64"]}`,
+		`{"components": [""]}`,
+		`[]`,
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
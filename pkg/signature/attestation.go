@@ -0,0 +1,282 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/containerd/containerd/v2/core/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	// ErrAttestationMissing is returned when no referrer satisfies an attestation requirement.
+	ErrAttestationMissing = errors.New("required attestation is missing")
+	// ErrAttestationPolicyViolation is returned when the referrers exist but violate the attestation policy.
+	ErrAttestationPolicyViolation = errors.New("attestation policy violation")
+)
+
+// MediaTypeDSSEEnvelope is the media type of DSSE envelopes.
+const MediaTypeDSSEEnvelope = "application/vnd.dsse.envelope.v1+json"
+
+// AttestationPolicy is the policy file of AttestationVerifier.
+//
+//	{
+//	  "requirements": [
+//	    {
+//	      "name": "provenance",
+//	      "artifactTypes": ["application/vnd.dev.sigstore.bundle.v0.3+json", "application/vnd.in-toto+json"],
+//	      "predicateType": "https://slsa.dev/provenance/v1",
+//	      "predicate": {"runDetails.builder.id": ["https://github.com/actions/runner/github-hosted"]},
+//	      "publicKeys": ["/etc/apt/apt-transport-oci/cosign.pub"]
+//	    },
+//	    {
+//	      "name": "sbom",
+//	      "artifactTypes": ["application/spdx+json", "application/vnd.cyclonedx+json"]
+//	    }
+//	  ]
+//	}
+type AttestationPolicy struct {
+	Requirements []AttestationRequirement `json:"requirements"`
+}
+
+// AttestationRequirement is a requirement of AttestationPolicy.
+// A requirement is satisfied when at least one of the referrers satisfies it.
+type AttestationRequirement struct {
+	// Name is the name of the requirement, used in error messages
+	Name string `json:"name"`
+	// RegistryScopes limits the repositories (e.g., "ghcr.io/foo/bar") that the requirement applies to.
+	// The requirement applies to all the repositories when empty.
+	RegistryScopes []string `json:"registryScopes,omitempty"`
+	// ArtifactTypes are the artifact types of the referrers
+	ArtifactTypes []string `json:"artifactTypes"`
+	// PredicateType is the predicate type of the in-toto statement (optional).
+	// When set, the referrer has to contain an in-toto statement, a DSSE envelope, or a Sigstore bundle
+	// with the subject of the root digest.
+	PredicateType string `json:"predicateType,omitempty"`
+	// Predicate maps the dot-separated paths of the predicate fields to the allowed values (optional).
+	// Predicate requires PublicKeys.
+	Predicate map[string][]string `json:"predicate,omitempty"`
+	// PublicKeys are the PEM files of the public keys that have to sign the DSSE envelope (optional).
+	PublicKeys []string `json:"publicKeys,omitempty"`
+
+	publicKeys []crypto.PublicKey
+}
+
+// AttestationVerifier verifies that the artifact has the referrers required by the policy,
+// such as SLSA provenances and SBOMs.
+type AttestationVerifier struct {
+	policy *AttestationPolicy
+}
+
+// NewAttestationVerifier instantiates AttestationVerifier with the policy file.
+func NewAttestationVerifier(policyFile string) (*AttestationVerifier, error) {
+	b, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	var policy AttestationPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", policyFile, err)
+	}
+	for i := range policy.Requirements {
+		req := &policy.Requirements[i]
+		if len(req.ArtifactTypes) == 0 {
+			return nil, fmt.Errorf("%q: requirement %q has no artifact type", policyFile, req.Name)
+		}
+		if len(req.Predicate) > 0 && req.PredicateType == "" {
+			return nil, fmt.Errorf("%q: requirement %q has predicate fields without the predicate type", policyFile, req.Name)
+		}
+		// Anyone who can push to the repository can push an unsigned statement with any predicate
+		if len(req.Predicate) > 0 && len(req.PublicKeys) == 0 {
+			return nil, fmt.Errorf("%q: requirement %q has predicate fields without the public keys", policyFile, req.Name)
+		}
+		if len(req.PublicKeys) > 0 {
+			if req.publicKeys, err = LoadPublicKeys(req.PublicKeys); err != nil {
+				return nil, fmt.Errorf("%q: requirement %q: %w", policyFile, req.Name, err)
+			}
+		}
+	}
+	return &AttestationVerifier{policy: &policy}, nil
+}
+
+// Name implements Verifier.
+func (v *AttestationVerifier) Name() string {
+	return "attestation"
+}
+
+// Verify implements Verifier.
+func (v *AttestationVerifier) Verify(ctx context.Context, t Target) error {
+	rf, ok := t.Fetcher.(remotes.ReferrersFetcher)
+	if !ok {
+		return errors.New("the fetcher does not support the referrers API")
+	}
+	for i := range v.policy.Requirements {
+		req := &v.policy.Requirements[i]
+		if len(req.RegistryScopes) > 0 && !slices.Contains(req.RegistryScopes, t.Ref.Name()) {
+			continue
+		}
+		referrers, err := rf.FetchReferrers(ctx, t.Desc.Digest, remotes.WithReferrerArtifactTypes(req.ArtifactTypes...))
+		if err != nil {
+			return fmt.Errorf("failed to fetch the referrers of %s: %w", t.Desc.Digest, err)
+		}
+		if len(referrers) == 0 {
+			return fmt.Errorf("%w: %q: %s@%s has no referrer of artifact type %s",
+				ErrAttestationMissing, req.Name, t.Ref.Name(), t.Desc.Digest, strings.Join(req.ArtifactTypes, ", "))
+		}
+		if err := req.verifyReferrers(ctx, t, referrers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (req *AttestationRequirement) verifyReferrers(ctx context.Context, t Target, referrers []ocispec.Descriptor) error {
+	if req.PredicateType == "" && len(req.publicKeys) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, referrer := range referrers {
+		err := req.verifyReferrer(ctx, t, referrer)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("referrer %s: %w", referrer.Digest, err))
+	}
+	return fmt.Errorf("%w: %q: no referrer of %s@%s satisfies the requirement: %w",
+		ErrAttestationPolicyViolation, req.Name, t.Ref.Name(), t.Desc.Digest, errors.Join(errs...))
+}
+
+func (req *AttestationRequirement) verifyReferrer(ctx context.Context, t Target, referrer ocispec.Descriptor) error {
+	b, err := ociutil.ReadBlob(ctx, t.Fetcher, referrer)
+	if err != nil {
+		return err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return err
+	}
+	var errs []error
+	for _, l := range manifest.Layers {
+		blob, err := ociutil.ReadBlob(ctx, t.Fetcher, l)
+		if err == nil {
+			err = req.verifyAttestation(t, blob)
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("layer %s: %w", l.Digest, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no layer")
+	}
+	return errors.Join(errs...)
+}
+
+// verifyAttestation verifies an in-toto statement, a DSSE envelope, or a Sigstore bundle.
+func (req *AttestationRequirement) verifyAttestation(t Target, blob []byte) error {
+	var doc struct {
+		// Sigstore bundle
+		DSSEEnvelope *DSSEEnvelope `json:"dsseEnvelope"`
+		// DSSE envelope
+		PayloadType string `json:"payloadType"`
+		// in-toto statement
+		Type string `json:"_type"`
+	}
+	if err := json.Unmarshal(blob, &doc); err != nil {
+		return fmt.Errorf("failed to parse the attestation: %w", err)
+	}
+	env := doc.DSSEEnvelope
+	if env == nil && doc.PayloadType != "" {
+		env = &DSSEEnvelope{}
+		if err := json.Unmarshal(blob, env); err != nil {
+			return fmt.Errorf("failed to parse the DSSE envelope: %w", err)
+		}
+	}
+	if len(req.publicKeys) > 0 {
+		if env == nil {
+			return errors.New("the attestation is not signed")
+		}
+		var errs []error
+		for _, pub := range req.publicKeys {
+			err := env.Verify(pub)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("invalid signature: %w", errors.Join(errs...))
+		}
+	}
+	if req.PredicateType == "" {
+		return nil
+	}
+	var stmt *InTotoStatement
+	switch {
+	case env != nil:
+		var err error
+		if stmt, err = env.Statement(); err != nil {
+			return err
+		}
+	case doc.Type != "":
+		stmt = &InTotoStatement{}
+		if err := json.Unmarshal(blob, stmt); err != nil {
+			return fmt.Errorf("failed to parse the in-toto statement: %w", err)
+		}
+	default:
+		return errors.New("not an in-toto attestation")
+	}
+	if !stmt.HasSubject(t.Desc.Digest) {
+		return fmt.Errorf("the in-toto statement does not have the subject %s", t.Desc.Digest)
+	}
+	if stmt.PredicateType != req.PredicateType {
+		return fmt.Errorf("expected predicate type %q, got %q", req.PredicateType, stmt.PredicateType)
+	}
+	if len(req.Predicate) == 0 {
+		return nil
+	}
+	var predicate interface{}
+	if err := json.Unmarshal(stmt.Predicate, &predicate); err != nil {
+		return fmt.Errorf("failed to parse the predicate: %w", err)
+	}
+	for field, allowed := range req.Predicate {
+		got, ok := lookupField(predicate, field)
+		if !ok {
+			return fmt.Errorf("the predicate lacks %q", field)
+		}
+		if !slices.Contains(allowed, got) {
+			return fmt.Errorf("expected the predicate field %q to be one of %q, got %q", field, allowed, got)
+		}
+	}
+	return nil
+}
+
+// lookupField looks up a dot-separated path like "runDetails.builder.id" in v.
+// Non-string scalar values are formatted with fmt.Sprint.
+func lookupField(v interface{}, path string) (string, bool) {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[k]; !ok {
+			return "", false
+		}
+	}
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case map[string]interface{}, []interface{}, nil:
+		return "", false
+	default:
+		return fmt.Sprint(x), true
+	}
+}
@@ -0,0 +1,118 @@
+package signature
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	refdocker "github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func addReferrer(t *testing.T, reg *memRegistry, subject ocispec.Descriptor, artifactType, layerMediaType string, layer []byte) {
+	layerDesc := reg.add(layerMediaType, layer)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       reg.add(ocispec.MediaTypeEmptyJSON, []byte("{}")),
+		Layers:       []ocispec.Descriptor{layerDesc},
+		Subject:      &subject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := reg.add(ocispec.MediaTypeImageManifest, manifest)
+	desc.ArtifactType = artifactType
+	reg.referrers[subject.Digest] = append(reg.referrers[subject.Digest], desc)
+}
+
+func TestAttestationVerifier(t *testing.T) {
+	ctx := context.Background()
+	ref, err := refdocker.ParseDockerRef("ghcr.io/foo/bar:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	key := newTestKey(t)
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubFile := filepath.Join(dir, "cosign.pub")
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policyFile := filepath.Join(dir, "attestation-policy.json")
+	writePolicy := func(publicKeys string) {
+		policy := fmt.Sprintf(`{"requirements":[
+{"name":"provenance","artifactTypes":["application/vnd.in-toto+json"],"predicateType":"https://slsa.dev/provenance/v1",
+ "predicate":{"runDetails.builder.id":["https://github.com/actions/runner/github-hosted"]}%s},
+{"name":"sbom","artifactTypes":["application/spdx+json"]}]}`, publicKeys)
+		if err := os.WriteFile(policyFile, []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The predicate fields of unsigned statements are not trusted
+	writePolicy("")
+	if _, err := NewAttestationVerifier(policyFile); err == nil {
+		t.Fatal("expected an error for the predicate fields without the public keys")
+	}
+	writePolicy(fmt.Sprintf(`,"publicKeys":[%q]`, pubFile))
+	v, err := NewAttestationVerifier(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance := func(subject ocispec.Descriptor, builderID string) []byte {
+		env := &DSSEEnvelope{
+			PayloadType: MediaTypeInToto,
+			Payload: []byte(fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"digest":{"sha256":%q}}],`+
+				`"predicateType":"https://slsa.dev/provenance/v1","predicate":{"runDetails":{"builder":{"id":%q}}}}`,
+				subject.Digest.Encoded(), builderID)),
+		}
+		env.Signatures = append(env.Signatures, DSSESignature{Sig: signTest(t, key, env.PAE())})
+		b, err := json.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	reg := newMemRegistry()
+	rootDesc := reg.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	target := Target{Ref: ref, Desc: rootDesc, Resolver: reg, Fetcher: reg}
+	if err := v.Verify(ctx, target); !errors.Is(err, ErrAttestationMissing) {
+		t.Fatalf("expected ErrAttestationMissing, got %v", err)
+	}
+
+	addReferrer(t, reg, rootDesc, MediaTypeInToto, MediaTypeDSSEEnvelope, provenance(rootDesc, "https://example.com/untrusted-builder"))
+	if err := v.Verify(ctx, target); !errors.Is(err, ErrAttestationPolicyViolation) {
+		t.Fatalf("expected ErrAttestationPolicyViolation, got %v", err)
+	}
+
+	// An unsigned statement is not accepted
+	unsigned := fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"digest":{"sha256":%q}}],`+
+		`"predicateType":"https://slsa.dev/provenance/v1","predicate":{"runDetails":{"builder":{"id":"https://github.com/actions/runner/github-hosted"}}}}`,
+		rootDesc.Digest.Encoded())
+	addReferrer(t, reg, rootDesc, MediaTypeInToto, MediaTypeInToto, []byte(unsigned))
+	if err := v.Verify(ctx, target); !errors.Is(err, ErrAttestationPolicyViolation) {
+		t.Fatalf("expected ErrAttestationPolicyViolation for the unsigned statement, got %v", err)
+	}
+
+	addReferrer(t, reg, rootDesc, MediaTypeInToto, MediaTypeDSSEEnvelope, provenance(rootDesc, "https://github.com/actions/runner/github-hosted"))
+	if err := v.Verify(ctx, target); !errors.Is(err, ErrAttestationMissing) {
+		t.Fatalf("expected ErrAttestationMissing for the SBOM, got %v", err)
+	}
+
+	addReferrer(t, reg, rootDesc, "application/spdx+json", "application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	if err := v.Verify(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+package signature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) codec for COSE envelopes (see NotationVerifier).
+// Indefinite-length items and duplicate map keys are not supported.
+
+// cborTag is a tagged CBOR data item.
+type cborTag struct {
+	Number  uint64
+	Content interface{}
+}
+
+const cborMaxDepth = 16
+
+// cborDecode decodes a single CBOR data item.
+// Integers are decoded as int64, maps as map[interface{}]interface{}.
+func cborDecode(b []byte) (interface{}, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(b) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) head() (major byte, arg uint64, err error) {
+	if d.off >= len(d.b) {
+		return 0, 0, errors.New("cbor: unexpected EOF")
+	}
+	ib := d.b[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+	if len(d.b)-d.off < n {
+		return 0, 0, errors.New("cbor: unexpected EOF")
+	}
+	var buf [8]byte
+	copy(buf[8-n:], d.b[d.off:d.off+n])
+	d.off += n
+	return major, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, errors.New("cbor: unexpected EOF")
+	}
+	b := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: too deep")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		return d.bytes(arg)
+	case 3:
+		b, err := d.bytes(arg)
+		return string(b), err
+	case 4:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, errors.New("cbor: unexpected EOF")
+		}
+		a := make([]interface{}, arg)
+		for i := range a {
+			if a[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case 5:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, errors.New("cbor: unexpected EOF")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key type %T", k)
+			}
+			// A duplicate key could hide a header from the verifier
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", k)
+			}
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		content, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{Number: arg, Content: content}, nil
+	default: // 7
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+	}
+}
+
+// cborEncode encodes v.
+// Supported types are int, int64, string, []byte, []interface{}, map[interface{}]interface{}, and cborTag.
+func cborEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cborEncodeTo(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cborWriteHead(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
+
+func cborEncodeTo(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case int:
+		return cborEncodeTo(buf, int64(x))
+	case int64:
+		if x >= 0 {
+			cborWriteHead(buf, 0, uint64(x))
+		} else {
+			cborWriteHead(buf, 1, uint64(-1-x))
+		}
+	case []byte:
+		cborWriteHead(buf, 2, uint64(len(x)))
+		buf.Write(x)
+	case string:
+		cborWriteHead(buf, 3, uint64(len(x)))
+		buf.WriteString(x)
+	case []interface{}:
+		cborWriteHead(buf, 4, uint64(len(x)))
+		for _, e := range x {
+			if err := cborEncodeTo(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		cborWriteHead(buf, 5, uint64(len(x)))
+		for k, e := range x {
+			if err := cborEncodeTo(buf, k); err != nil {
+				return err
+			}
+			if err := cborEncodeTo(buf, e); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		cborWriteHead(buf, 6, x.Number)
+		return cborEncodeTo(buf, x.Content)
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package signature
+
+import (
+	"testing"
+)
+
+func TestCBORDecodeDuplicateMapKeys(t *testing.T) {
+	// {1: -7, 1: -8}
+	if _, err := cborDecode([]byte{0xa2, 0x01, 0x26, 0x01, 0x27}); err == nil {
+		t.Fatal("expected an error for the duplicate map keys")
+	}
+	// {1: -7, 4: "kid"}
+	v, err := cborDecode([]byte{0xa2, 0x01, 0x26, 0x04, 0x63, 'k', 'i', 'd'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || m[int64(1)] != int64(-7) || m[int64(4)] != "kid" {
+		t.Fatalf("unexpected map %v", v)
+	}
+}
@@ -0,0 +1,362 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Cosign media types and annotations.
+// https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+const (
+	MediaTypeCosignSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	ArtifactTypeCosignSignature  = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	AnnotationCosignSignature    = "dev.cosignproject.cosign/signature"
+
+	// cosignSimpleSigningType is the "critical.type" of the simple signing payload
+	cosignSimpleSigningType = "cosign container image signature"
+
+	// ArtifactTypeSigstoreBundle is the artifact type of the Sigstore bundle v0.3.
+	// https://github.com/sigstore/protobuf-specs/blob/main/protos/sigstore_bundle.proto
+	ArtifactTypeSigstoreBundle = "application/vnd.dev.sigstore.bundle.v0.3+json"
+)
+
+// CosignVerifier verifies cosign signatures offline with public keys.
+//
+// The signatures are looked up in the following order:
+//   - the "sha256-<hex>.sig" tag (simple signing)
+//   - OCI 1.1 referrers (simple signing, or Sigstore bundles)
+//
+// Sigstore bundles stored on the local filesystem (e.g., "cosign sign --bundle")
+// are also tried.
+//
+// Keyless signatures (Fulcio certificates) are not supported.
+type CosignVerifier struct {
+	publicKeys []crypto.PublicKey
+	bundles    []cosignSignature
+}
+
+// NewCosignVerifier instantiates CosignVerifier with PEM-encoded public key files,
+// and optionally with Sigstore bundle files.
+func NewCosignVerifier(publicKeyFiles, bundleFiles []string) (*CosignVerifier, error) {
+	keys, err := LoadPublicKeys(publicKeyFiles)
+	if err != nil {
+		return nil, err
+	}
+	v := &CosignVerifier{publicKeys: keys}
+	for _, f := range bundleFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var bundle sigstoreBundle
+		if err := json.Unmarshal(b, &bundle); err != nil {
+			return nil, fmt.Errorf("failed to parse the Sigstore bundle %q: %w", f, err)
+		}
+		v.bundles = append(v.bundles, cosignSignature{
+			source: f,
+			bundle: &bundle,
+		})
+	}
+	return v, nil
+}
+
+// Name implements Verifier.
+func (v *CosignVerifier) Name() string {
+	return "cosign"
+}
+
+// cosignSignature is a candidate of the signature.
+type cosignSignature struct {
+	// source describes where the signature was found
+	source string
+	// either simpleSigning or bundle is set
+	simpleSigning *simpleSigningSignature
+	bundle        *sigstoreBundle
+}
+
+type simpleSigningSignature struct {
+	payload   []byte
+	signature []byte
+}
+
+// simpleSigningPayload is the payload of the simple signing format.
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// sigstoreBundle is a subset of the Sigstore bundle in the protobuf JSON encoding.
+type sigstoreBundle struct {
+	MediaType        string `json:"mediaType"`
+	MessageSignature *struct {
+		MessageDigest struct {
+			Algorithm string `json:"algorithm"`
+			Digest    []byte `json:"digest"`
+		} `json:"messageDigest"`
+		Signature []byte `json:"signature"`
+	} `json:"messageSignature,omitempty"`
+	DSSEEnvelope *DSSEEnvelope `json:"dsseEnvelope,omitempty"`
+}
+
+// Verify implements Verifier.
+func (v *CosignVerifier) Verify(ctx context.Context, t Target) error {
+	sigs, err := v.findSignatures(ctx, t)
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("no cosign signature was found for %s@%s", t.Ref.Name(), t.Desc.Digest)
+	}
+	var errs []error
+	for _, sig := range sigs {
+		err := v.verifySignature(t.Desc, sig)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", sig.source, err))
+	}
+	return fmt.Errorf("no valid cosign signature was found for %s@%s: %w", t.Ref.Name(), t.Desc.Digest, errors.Join(errs...))
+}
+
+func (v *CosignVerifier) findSignatures(ctx context.Context, t Target) ([]cosignSignature, error) {
+	sigs := append([]cosignSignature(nil), v.bundles...)
+
+	sigTag := fmt.Sprintf("%s-%s.sig", t.Desc.Digest.Algorithm(), t.Desc.Digest.Encoded())
+	sigRef, err := refdocker.WithTag(refdocker.TrimNamed(t.Ref), sigTag)
+	if err != nil {
+		return nil, err
+	}
+	_, sigDesc, err := t.Resolver.Resolve(ctx, sigRef.String())
+	switch {
+	case err == nil:
+		found, err := v.signaturesFromManifest(ctx, t.Fetcher, sigDesc, sigRef.String())
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, found...)
+	case errdefs.IsNotFound(err):
+		// NOP
+	default:
+		return nil, fmt.Errorf("failed to resolve %q: %w", sigRef, err)
+	}
+
+	if rf, ok := t.Fetcher.(remotes.ReferrersFetcher); ok {
+		referrers, err := rf.FetchReferrers(ctx, t.Desc.Digest,
+			remotes.WithReferrerArtifactTypes(ArtifactTypeCosignSignature, ArtifactTypeSigstoreBundle))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the referrers of %s: %w", t.Desc.Digest, err)
+		}
+		for _, referrer := range referrers {
+			found, err := v.signaturesFromManifest(ctx, t.Fetcher, referrer, "referrer "+referrer.Digest.String())
+			if err != nil {
+				return nil, err
+			}
+			sigs = append(sigs, found...)
+		}
+	}
+	return sigs, nil
+}
+
+func (v *CosignVerifier) signaturesFromManifest(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, source string) ([]cosignSignature, error) {
+	b, err := ociutil.ReadBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the signature manifest %s: %w", desc.Digest, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	var sigs []cosignSignature
+	for _, l := range manifest.Layers {
+		switch {
+		case l.MediaType == MediaTypeCosignSimpleSigning:
+			sigB64 := l.Annotations[AnnotationCosignSignature]
+			if sigB64 == "" {
+				continue
+			}
+			sig, err := base64.StdEncoding.DecodeString(sigB64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the signature in %s: %w", source, err)
+			}
+			payload, err := ociutil.ReadBlob(ctx, fetcher, l)
+			if err != nil {
+				return nil, err
+			}
+			sigs = append(sigs, cosignSignature{
+				source: source,
+				simpleSigning: &simpleSigningSignature{
+					payload:   payload,
+					signature: sig,
+				},
+			})
+		case strings.HasPrefix(l.MediaType, "application/vnd.dev.sigstore.bundle."):
+			blob, err := ociutil.ReadBlob(ctx, fetcher, l)
+			if err != nil {
+				return nil, err
+			}
+			var bundle sigstoreBundle
+			if err := json.Unmarshal(blob, &bundle); err != nil {
+				return nil, fmt.Errorf("failed to parse the Sigstore bundle in %s: %w", source, err)
+			}
+			sigs = append(sigs, cosignSignature{
+				source: source,
+				bundle: &bundle,
+			})
+		}
+	}
+	return sigs, nil
+}
+
+func (v *CosignVerifier) verifySignature(desc ocispec.Descriptor, sig cosignSignature) error {
+	switch {
+	case sig.simpleSigning != nil:
+		var payload simpleSigningPayload
+		if err := json.Unmarshal(sig.simpleSigning.payload, &payload); err != nil {
+			return fmt.Errorf("failed to parse the simple signing payload: %w", err)
+		}
+		if got := payload.Critical.Type; got != cosignSimpleSigningType {
+			return fmt.Errorf("expected the simple signing type to be %q, got %q", cosignSimpleSigningType, got)
+		}
+		if got := payload.Critical.Image.DockerManifestDigest; got != desc.Digest {
+			return fmt.Errorf("expected the signed digest to be %s, got %s", desc.Digest, got)
+		}
+		return v.verifyWithAnyKey(func(pub crypto.PublicKey) error {
+			return verifyMessage(pub, sig.simpleSigning.payload, sig.simpleSigning.signature)
+		})
+	case sig.bundle != nil && sig.bundle.MessageSignature != nil:
+		ms := sig.bundle.MessageSignature
+		if ms.MessageDigest.Algorithm != "SHA2_256" {
+			return fmt.Errorf("unsupported message digest algorithm %q", ms.MessageDigest.Algorithm)
+		}
+		if got := digest.NewDigestFromBytes(digest.SHA256, ms.MessageDigest.Digest); got != desc.Digest {
+			return fmt.Errorf("expected the signed digest to be %s, got %s", desc.Digest, got)
+		}
+		return v.verifyWithAnyKey(func(pub crypto.PublicKey) error {
+			return verifyDigest(pub, crypto.SHA256, ms.MessageDigest.Digest, ms.Signature)
+		})
+	case sig.bundle != nil && sig.bundle.DSSEEnvelope != nil:
+		env := sig.bundle.DSSEEnvelope
+		if err := v.verifyWithAnyKey(env.Verify); err != nil {
+			return err
+		}
+		stmt, err := env.Statement()
+		if err != nil {
+			return err
+		}
+		if !stmt.HasSubject(desc.Digest) {
+			return fmt.Errorf("the in-toto statement does not have the subject %s", desc.Digest)
+		}
+		return nil
+	default:
+		return errors.New("unsupported signature format")
+	}
+}
+
+func (v *CosignVerifier) verifyWithAnyKey(f func(crypto.PublicKey) error) error {
+	var errs []error
+	for _, pub := range v.publicKeys {
+		err := f(pub)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// DSSEEnvelope is a DSSE envelope.
+// https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type DSSEEnvelope struct {
+	Payload     []byte          `json:"payload"`
+	PayloadType string          `json:"payloadType"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a signature in DSSEEnvelope.
+type DSSESignature struct {
+	Sig   []byte `json:"sig"`
+	KeyID string `json:"keyid,omitempty"`
+}
+
+// PAE returns the pre-authentication encoding of the envelope.
+func (env *DSSEEnvelope) PAE() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "DSSEv1 %d %s %d ", len(env.PayloadType), env.PayloadType, len(env.Payload))
+	buf.Write(env.Payload)
+	return buf.Bytes()
+}
+
+// Verify returns nil if one of the signatures is valid for pub.
+func (env *DSSEEnvelope) Verify(pub crypto.PublicKey) error {
+	if len(env.Signatures) == 0 {
+		return errors.New("no signature in the DSSE envelope")
+	}
+	pae := env.PAE()
+	var errs []error
+	for _, sig := range env.Signatures {
+		err := verifyMessage(pub, pae, sig.Sig)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// MediaTypeInToto is the payload type of in-toto statements.
+const MediaTypeInToto = "application/vnd.in-toto+json"
+
+// InTotoStatement is an in-toto statement.
+// https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+type InTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name,omitempty"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate,omitempty"`
+}
+
+// Statement parses the payload as an in-toto statement.
+func (env *DSSEEnvelope) Statement() (*InTotoStatement, error) {
+	if env.PayloadType != MediaTypeInToto {
+		return nil, fmt.Errorf("expected payload type %q, got %q", MediaTypeInToto, env.PayloadType)
+	}
+	var stmt InTotoStatement
+	if err := json.Unmarshal(env.Payload, &stmt); err != nil {
+		return nil, fmt.Errorf("failed to parse the in-toto statement: %w", err)
+	}
+	return &stmt, nil
+}
+
+// HasSubject returns true if the statement has the subject with the digest.
+func (stmt *InTotoStatement) HasSubject(dgst digest.Digest) bool {
+	for _, subj := range stmt.Subject {
+		if subj.Digest[dgst.Algorithm().String()] == dgst.Encoded() {
+			return true
+		}
+	}
+	return false
+}
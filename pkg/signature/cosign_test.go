@@ -0,0 +1,200 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// memRegistry is an in-memory registry, implementing remotes.Resolver and remotes.Fetcher.
+// It also implements remotes.ReferrersFetcher.
+type memRegistry struct {
+	blobs     map[digest.Digest][]byte
+	tags      map[string]ocispec.Descriptor
+	referrers map[digest.Digest][]ocispec.Descriptor
+}
+
+func newMemRegistry() *memRegistry {
+	return &memRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		tags:      make(map[string]ocispec.Descriptor),
+		referrers: make(map[digest.Digest][]ocispec.Descriptor),
+	}
+}
+
+func (r *memRegistry) add(mediaType string, b []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	r.blobs[desc.Digest] = b
+	return desc
+}
+
+func (r *memRegistry) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	desc, ok := r.tags[ref]
+	if !ok {
+		return "", ocispec.Descriptor{}, fmt.Errorf("%s: %w", ref, errdefs.ErrNotFound)
+	}
+	return ref, desc, nil
+}
+
+func (r *memRegistry) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	return r, nil
+}
+
+func (r *memRegistry) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	return nil, errdefs.ErrNotImplemented
+}
+
+func (r *memRegistry) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	b, ok := r.blobs[desc.Digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (r *memRegistry) FetchReferrers(ctx context.Context, dgst digest.Digest, opts ...remotes.FetchReferrersOpt) ([]ocispec.Descriptor, error) {
+	var config remotes.FetchReferrersConfig
+	for _, o := range opts {
+		if err := o(ctx, &config); err != nil {
+			return nil, err
+		}
+	}
+	var res []ocispec.Descriptor
+	for _, desc := range r.referrers[dgst] {
+		if len(config.ArtifactTypes) == 0 || slices.Contains(config.ArtifactTypes, desc.ArtifactType) {
+			res = append(res, desc)
+		}
+	}
+	return res, nil
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func signTest(t *testing.T, k *ecdsa.PrivateKey, message []byte) []byte {
+	h := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, k, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestCosignVerifierSimpleSigning(t *testing.T) {
+	ctx := context.Background()
+	reg := newMemRegistry()
+	ref, err := refdocker.ParseDockerRef("ghcr.io/foo/bar:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDesc := reg.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	reg.tags[ref.String()] = rootDesc
+
+	key := newTestKey(t)
+	newPayload := func(typ string) []byte {
+		return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"ghcr.io/foo/bar"},"image":{"docker-manifest-digest":%q},"type":%q}}`, rootDesc.Digest, typ))
+	}
+	payload := newPayload("cosign container image signature")
+	sign := func(k *ecdsa.PrivateKey) {
+		layer := reg.add(MediaTypeCosignSimpleSigning, payload)
+		layer.Annotations = map[string]string{
+			AnnotationCosignSignature: base64.StdEncoding.EncodeToString(signTest(t, k, payload)),
+		}
+		manifest, err := json.Marshal(ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Layers:    []ocispec.Descriptor{layer},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg.tags["ghcr.io/foo/bar:sha256-"+rootDesc.Digest.Encoded()+".sig"] = reg.add(ocispec.MediaTypeImageManifest, manifest)
+	}
+
+	v := &CosignVerifier{publicKeys: []crypto.PublicKey{key.Public()}}
+	target := Target{Ref: ref, Desc: rootDesc, Resolver: reg, Fetcher: reg}
+	if err := v.Verify(ctx, target); err == nil {
+		t.Fatal("expected an error for the unsigned artifact")
+	}
+
+	sign(newTestKey(t))
+	if err := v.Verify(ctx, target); err == nil {
+		t.Fatal("expected an error for the signature with an unknown key")
+	}
+
+	payload = newPayload("atomic container signature")
+	sign(key)
+	if err := v.Verify(ctx, target); err == nil {
+		t.Fatal("expected an error for the signature of another type")
+	}
+
+	payload = newPayload("cosign container image signature")
+	sign(key)
+	if err := v.Verify(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+
+	otherDesc := reg.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"layers":[]}`))
+	reg.tags["ghcr.io/foo/bar:sha256-"+otherDesc.Digest.Encoded()+".sig"] = reg.tags["ghcr.io/foo/bar:sha256-"+rootDesc.Digest.Encoded()+".sig"]
+	if err := v.Verify(ctx, Target{Ref: ref, Desc: otherDesc, Resolver: reg, Fetcher: reg}); err == nil {
+		t.Fatal("expected an error for the signature of another digest")
+	}
+}
+
+func TestCosignVerifierBundleDSSE(t *testing.T) {
+	ctx := context.Background()
+	reg := newMemRegistry()
+	ref, err := refdocker.ParseDockerRef("ghcr.io/foo/bar:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDesc := reg.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+
+	key := newTestKey(t)
+	env := &DSSEEnvelope{
+		PayloadType: MediaTypeInToto,
+		Payload: []byte(fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"name":"ghcr.io/foo/bar","digest":{"sha256":%q}}],"predicateType":"https://cosign.sigstore.dev/attestation/v1","predicate":{}}`,
+			rootDesc.Digest.Encoded())),
+	}
+	env.Signatures = append(env.Signatures, DSSESignature{Sig: signTest(t, key, env.PAE())})
+
+	v := &CosignVerifier{
+		publicKeys: []crypto.PublicKey{key.Public()},
+		bundles: []cosignSignature{{
+			source: "bundle.json",
+			bundle: &sigstoreBundle{DSSEEnvelope: env},
+		}},
+	}
+	if err := v.Verify(ctx, Target{Ref: ref, Desc: rootDesc, Resolver: reg, Fetcher: reg}); err != nil {
+		t.Fatal(err)
+	}
+
+	env.Payload = bytes.Replace(env.Payload, []byte(rootDesc.Digest.Encoded()), []byte(digest.FromString("x").Encoded()), 1)
+	if err := v.Verify(ctx, Target{Ref: ref, Desc: rootDesc, Resolver: reg, Fetcher: reg}); err == nil {
+		t.Fatal("expected an error for the tampered payload")
+	}
+}
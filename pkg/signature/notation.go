@@ -0,0 +1,748 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AkihiroSuda/apt-transport-oci/pkg/ociutil"
+	"github.com/containerd/containerd/v2/core/remotes"
+	refdocker "github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Notary Project media types.
+// https://github.com/notaryproject/specifications/blob/main/specs/signature-specification.md
+const (
+	ArtifactTypeNotationSignature = "application/vnd.cncf.notary.signature"
+	MediaTypeNotationPayload      = "application/vnd.cncf.notary.payload.v1+json"
+	MediaTypeJWS                  = "application/jose+json"
+	MediaTypeCOSE                 = "application/cose"
+)
+
+// Notary Project signature headers.
+const (
+	notationHeaderSigningScheme        = "io.cncf.notary.signingScheme"
+	notationHeaderSigningTime          = "io.cncf.notary.signingTime"
+	notationHeaderAuthenticSigningTime = "io.cncf.notary.authenticSigningTime"
+	notationHeaderExpiry               = "io.cncf.notary.expiry"
+
+	notationSchemeX509                 = "notary.x509"
+	notationSchemeX509SigningAuthority = "notary.x509.signingAuthority"
+)
+
+// NotationVerifier verifies Notary Project signatures offline,
+// with the trust store and the trust policy in the notation configuration directory:
+//
+//	<configDir>/trustpolicy.oci.json (or trustpolicy.json)
+//	<configDir>/truststore/x509/{ca,signingAuthority}/<name>/*.{pem,crt}
+//
+// The signatures are discovered from the OCI 1.1 referrers.
+//
+// Revocation checks (OCSP, CRL) and timestamping are not supported.
+//
+// The verifier is implemented with the standard library rather than with notation-go,
+// as notation-go would bring notation-core-go, go-cose, oras-go, and the plugin framework
+// into the method binary, which only needs to verify the JWS and COSE envelopes offline.
+type NotationVerifier struct {
+	configDir string
+	policy    *notationTrustPolicyDocument
+}
+
+// NewNotationVerifier instantiates NotationVerifier with the notation configuration directory, such as "/etc/notation".
+func NewNotationVerifier(configDir string) (*NotationVerifier, error) {
+	var (
+		b   []byte
+		err error
+	)
+	for _, f := range []string{"trustpolicy.oci.json", "trustpolicy.json"} {
+		b, err = os.ReadFile(filepath.Join(configDir, f))
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the trust policy in %q: %w", configDir, err)
+	}
+	var policy notationTrustPolicyDocument
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse the trust policy in %q: %w", configDir, err)
+	}
+	for i := range policy.TrustPolicies {
+		if _, err := policy.TrustPolicies[i].actions(); err != nil {
+			return nil, err
+		}
+	}
+	return &NotationVerifier{configDir: configDir, policy: &policy}, nil
+}
+
+// Name implements Verifier.
+func (v *NotationVerifier) Name() string {
+	return "notation"
+}
+
+// notationTrustPolicyDocument is the trust policy.
+// https://github.com/notaryproject/specifications/blob/main/specs/trust-store-trust-policy.md
+type notationTrustPolicyDocument struct {
+	Version       string                `json:"version"`
+	TrustPolicies []notationTrustPolicy `json:"trustPolicies"`
+}
+
+type notationTrustPolicy struct {
+	Name                  string   `json:"name"`
+	RegistryScopes        []string `json:"registryScopes"`
+	SignatureVerification struct {
+		Level    string            `json:"level"`
+		Override map[string]string `json:"override,omitempty"`
+	} `json:"signatureVerification"`
+	TrustStores       []string `json:"trustStores,omitempty"`
+	TrustedIdentities []string `json:"trustedIdentities,omitempty"`
+}
+
+// Validation actions.
+const (
+	notationActionEnforce = "enforce"
+	notationActionLog     = "log"
+	notationActionSkip    = "skip"
+)
+
+// Validations.
+const (
+	notationValidationIntegrity          = "integrity"
+	notationValidationAuthenticity       = "authenticity"
+	notationValidationAuthenticTimestamp = "authenticTimestamp"
+	notationValidationExpiry             = "expiry"
+	notationValidationRevocation         = "revocation"
+)
+
+var notationLevels = map[string]map[string]string{
+	"strict": {
+		notationValidationIntegrity:          notationActionEnforce,
+		notationValidationAuthenticity:       notationActionEnforce,
+		notationValidationAuthenticTimestamp: notationActionEnforce,
+		notationValidationExpiry:             notationActionEnforce,
+		notationValidationRevocation:         notationActionEnforce,
+	},
+	"permissive": {
+		notationValidationIntegrity:          notationActionEnforce,
+		notationValidationAuthenticity:       notationActionEnforce,
+		notationValidationAuthenticTimestamp: notationActionLog,
+		notationValidationExpiry:             notationActionLog,
+		notationValidationRevocation:         notationActionLog,
+	},
+	"audit": {
+		notationValidationIntegrity:          notationActionEnforce,
+		notationValidationAuthenticity:       notationActionLog,
+		notationValidationAuthenticTimestamp: notationActionLog,
+		notationValidationExpiry:             notationActionLog,
+		notationValidationRevocation:         notationActionLog,
+	},
+	"skip": {
+		notationValidationIntegrity:          notationActionSkip,
+		notationValidationAuthenticity:       notationActionSkip,
+		notationValidationAuthenticTimestamp: notationActionSkip,
+		notationValidationExpiry:             notationActionSkip,
+		notationValidationRevocation:         notationActionSkip,
+	},
+}
+
+// actions returns the actions of the validations, with the overrides applied.
+func (p *notationTrustPolicy) actions() (map[string]string, error) {
+	level, ok := notationLevels[p.SignatureVerification.Level]
+	if !ok {
+		return nil, fmt.Errorf("trust policy %q: unknown signature verification level %q", p.Name, p.SignatureVerification.Level)
+	}
+	actions := make(map[string]string, len(level))
+	for k, a := range level {
+		actions[k] = a
+	}
+	for k, a := range p.SignatureVerification.Override {
+		if _, ok := actions[k]; !ok || k == notationValidationIntegrity {
+			return nil, fmt.Errorf("trust policy %q: the validation %q cannot be overridden", p.Name, k)
+		}
+		switch a {
+		case notationActionEnforce, notationActionLog, notationActionSkip:
+		default:
+			return nil, fmt.Errorf("trust policy %q: unknown action %q", p.Name, a)
+		}
+		if p.SignatureVerification.Level == "skip" {
+			return nil, fmt.Errorf("trust policy %q: the level \"skip\" cannot be overridden", p.Name)
+		}
+		actions[k] = a
+	}
+	return actions, nil
+}
+
+// policyFor returns the trust policy for the repository.
+// A policy with the exact registry scope is preferred over the wildcard policy ("*").
+func (v *NotationVerifier) policyFor(ref refdocker.Named) (*notationTrustPolicy, error) {
+	name := ref.Name()
+	var wildcard *notationTrustPolicy
+	for i := range v.policy.TrustPolicies {
+		p := &v.policy.TrustPolicies[i]
+		for _, scope := range p.RegistryScopes {
+			switch scope {
+			case name:
+				return p, nil
+			case "*":
+				wildcard = p
+			}
+		}
+	}
+	if wildcard == nil {
+		return nil, fmt.Errorf("no trust policy is applicable to %q", name)
+	}
+	return wildcard, nil
+}
+
+// Verify implements Verifier.
+func (v *NotationVerifier) Verify(ctx context.Context, t Target) error {
+	policy, err := v.policyFor(t.Ref)
+	if err != nil {
+		return err
+	}
+	actions, err := policy.actions()
+	if err != nil {
+		return err
+	}
+	if actions[notationValidationIntegrity] == notationActionSkip {
+		return nil
+	}
+	if actions[notationValidationRevocation] != notationActionSkip {
+		t.warnf("notation: revocation checks are not supported (trust policy %q)", policy.Name)
+	}
+	rf, ok := t.Fetcher.(remotes.ReferrersFetcher)
+	if !ok {
+		return errors.New("the fetcher does not support the referrers API")
+	}
+	referrers, err := rf.FetchReferrers(ctx, t.Desc.Digest, remotes.WithReferrerArtifactTypes(ArtifactTypeNotationSignature))
+	if err != nil {
+		return fmt.Errorf("failed to fetch the referrers of %s: %w", t.Desc.Digest, err)
+	}
+	if len(referrers) == 0 {
+		return fmt.Errorf("no notation signature was found for %s@%s", t.Ref.Name(), t.Desc.Digest)
+	}
+	var errs []error
+	for _, referrer := range referrers {
+		sig, err := v.fetchSignature(ctx, t.Fetcher, referrer)
+		if err == nil {
+			err = v.verifySignature(t, policy, actions, sig)
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("referrer %s: %w", referrer.Digest, err))
+	}
+	return fmt.Errorf("no valid notation signature was found for %s@%s: %w", t.Ref.Name(), t.Desc.Digest, errors.Join(errs...))
+}
+
+// notationSignature is a parsed signature envelope.
+type notationSignature struct {
+	// alg is the JWS algorithm name, such as "PS256" and "ES256".
+	// COSE algorithms are converted to the JWS names.
+	alg                  string
+	contentType          string
+	crit                 []string
+	signingScheme        string
+	signingTime          time.Time
+	authenticSigningTime time.Time
+	expiry               time.Time
+	// certs is the certificate chain, starting from the signing certificate
+	certs      []*x509.Certificate
+	payload    []byte
+	signedData []byte
+	signature  []byte
+}
+
+// notationPayload is the payload of the signature envelope.
+type notationPayload struct {
+	TargetArtifact ocispec.Descriptor `json:"targetArtifact"`
+}
+
+func (v *NotationVerifier) fetchSignature(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) (*notationSignature, error) {
+	b, err := ociutil.ReadBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.ArtifactType != ArtifactTypeNotationSignature && manifest.Config.MediaType != ArtifactTypeNotationSignature {
+		return nil, fmt.Errorf("unexpected artifact type %q", manifest.ArtifactType)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("expected 1 layer, got %d", len(manifest.Layers))
+	}
+	envDesc := manifest.Layers[0]
+	env, err := ociutil.ReadBlob(ctx, fetcher, envDesc)
+	if err != nil {
+		return nil, err
+	}
+	switch envDesc.MediaType {
+	case MediaTypeJWS:
+		return parseNotationJWS(env)
+	case MediaTypeCOSE:
+		return parseNotationCOSE(env)
+	default:
+		return nil, fmt.Errorf("unsupported signature envelope type %q", envDesc.MediaType)
+	}
+}
+
+// parseNotationJWS parses a JWS envelope in the JSON serialization.
+// https://github.com/notaryproject/specifications/blob/main/specs/signature-envelope-jws.md
+func parseNotationJWS(b []byte) (*notationSignature, error) {
+	var env struct {
+		Payload   string `json:"payload"`
+		Protected string `json:"protected"`
+		Header    struct {
+			X5C [][]byte `json:"x5c"`
+		} `json:"header"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse the JWS envelope: %w", err)
+	}
+	protectedJSON, err := base64.RawURLEncoding.DecodeString(env.Protected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the JWS protected header: %w", err)
+	}
+	var protected struct {
+		Alg                  string     `json:"alg"`
+		Cty                  string     `json:"cty"`
+		Crit                 []string   `json:"crit"`
+		SigningScheme        string     `json:"io.cncf.notary.signingScheme"`
+		SigningTime          *time.Time `json:"io.cncf.notary.signingTime"`
+		AuthenticSigningTime *time.Time `json:"io.cncf.notary.authenticSigningTime"`
+		Expiry               *time.Time `json:"io.cncf.notary.expiry"`
+	}
+	if err := json.Unmarshal(protectedJSON, &protected); err != nil {
+		return nil, fmt.Errorf("failed to parse the JWS protected header: %w", err)
+	}
+	sig := &notationSignature{
+		alg:           protected.Alg,
+		contentType:   protected.Cty,
+		crit:          protected.Crit,
+		signingScheme: protected.SigningScheme,
+		signedData:    []byte(env.Protected + "." + env.Payload),
+	}
+	for _, t := range []struct {
+		dst *time.Time
+		src *time.Time
+	}{
+		{&sig.signingTime, protected.SigningTime},
+		{&sig.authenticSigningTime, protected.AuthenticSigningTime},
+		{&sig.expiry, protected.Expiry},
+	} {
+		if t.src != nil {
+			*t.dst = *t.src
+		}
+	}
+	if sig.payload, err = base64.RawURLEncoding.DecodeString(env.Payload); err != nil {
+		return nil, fmt.Errorf("failed to decode the JWS payload: %w", err)
+	}
+	if sig.signature, err = base64.RawURLEncoding.DecodeString(env.Signature); err != nil {
+		return nil, fmt.Errorf("failed to decode the JWS signature: %w", err)
+	}
+	for _, der := range env.Header.X5C {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the certificate chain: %w", err)
+		}
+		sig.certs = append(sig.certs, cert)
+	}
+	return sig, nil
+}
+
+// COSE header labels and algorithms.
+// https://www.iana.org/assignments/cose/cose.xhtml
+const (
+	coseTagSign1        = 18
+	coseHeaderAlg       = 1
+	coseHeaderCrit      = 2
+	coseHeaderCty       = 3
+	coseHeaderX5Chain   = 33
+	cborTagEpochSeconds = 1
+)
+
+var coseAlgs = map[int64]string{
+	-7:  "ES256",
+	-35: "ES384",
+	-36: "ES512",
+	-37: "PS256",
+	-38: "PS384",
+	-39: "PS512",
+}
+
+// parseNotationCOSE parses a COSE_Sign1 envelope.
+// https://github.com/notaryproject/specifications/blob/main/specs/signature-envelope-cose.md
+func parseNotationCOSE(b []byte) (*notationSignature, error) {
+	v, err := cborDecode(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the COSE envelope: %w", err)
+	}
+	if tag, ok := v.(cborTag); ok {
+		if tag.Number != coseTagSign1 {
+			return nil, fmt.Errorf("expected COSE_Sign1 (tag %d), got tag %d", coseTagSign1, tag.Number)
+		}
+		v = tag.Content
+	}
+	a, ok := v.([]interface{})
+	if !ok || len(a) != 4 {
+		return nil, errors.New("malformed COSE_Sign1")
+	}
+	protectedBytes, ok1 := a[0].([]byte)
+	unprotected, ok2 := a[1].(map[interface{}]interface{})
+	payload, ok3 := a[2].([]byte)
+	signature, ok4 := a[3].([]byte)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, errors.New("malformed COSE_Sign1")
+	}
+	pv, err := cborDecode(protectedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the COSE protected header: %w", err)
+	}
+	protected, ok := pv.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("malformed COSE protected header")
+	}
+	sig := &notationSignature{
+		payload:   payload,
+		signature: signature,
+	}
+	if alg, ok := protected[int64(coseHeaderAlg)].(int64); ok {
+		sig.alg = coseAlgs[alg]
+	}
+	sig.contentType, _ = protected[int64(coseHeaderCty)].(string)
+	if crit, ok := protected[int64(coseHeaderCrit)].([]interface{}); ok {
+		for _, c := range crit {
+			sig.crit = append(sig.crit, fmt.Sprint(c))
+		}
+	}
+	sig.signingScheme, _ = protected[notationHeaderSigningScheme].(string)
+	for k, dst := range map[string]*time.Time{
+		notationHeaderSigningTime:          &sig.signingTime,
+		notationHeaderAuthenticSigningTime: &sig.authenticSigningTime,
+		notationHeaderExpiry:               &sig.expiry,
+	} {
+		if tag, ok := protected[k].(cborTag); ok && tag.Number == cborTagEpochSeconds {
+			if sec, ok := tag.Content.(int64); ok {
+				*dst = time.Unix(sec, 0)
+			}
+		}
+	}
+	var chain []interface{}
+	switch x := unprotected[int64(coseHeaderX5Chain)].(type) {
+	case []byte:
+		chain = []interface{}{x}
+	case []interface{}:
+		chain = x
+	}
+	for _, c := range chain {
+		der, ok := c.([]byte)
+		if !ok {
+			return nil, errors.New("malformed COSE x5chain")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the certificate chain: %w", err)
+		}
+		sig.certs = append(sig.certs, cert)
+	}
+	sigStructure := []interface{}{"Signature1", protectedBytes, []byte{}, payload}
+	if sig.signedData, err = cborEncode(sigStructure); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (v *NotationVerifier) verifySignature(t Target, policy *notationTrustPolicy, actions map[string]string, sig *notationSignature) error {
+	// Integrity
+	if err := sig.verifyIntegrity(t.Desc); err != nil {
+		return err
+	}
+
+	// Authenticity
+	if a := actions[notationValidationAuthenticity]; a != notationActionSkip {
+		verifyTime := time.Now()
+		if actions[notationValidationAuthenticTimestamp] != notationActionEnforce && !sig.signingTime.IsZero() {
+			verifyTime = sig.signingTime
+		}
+		if sig.signingScheme == notationSchemeX509SigningAuthority {
+			verifyTime = sig.authenticSigningTime
+		}
+		if err := v.verifyAuthenticity(policy, sig, verifyTime); err != nil {
+			if a == notationActionEnforce {
+				return err
+			}
+			t.warnf("notation: %v", err)
+		}
+	}
+
+	// Expiry
+	if a := actions[notationValidationExpiry]; a != notationActionSkip && !sig.expiry.IsZero() && time.Now().After(sig.expiry) {
+		err := fmt.Errorf("the signature expired at %s", sig.expiry)
+		if a == notationActionEnforce {
+			return err
+		}
+		t.warnf("notation: %v", err)
+	}
+	return nil
+}
+
+func (sig *notationSignature) verifyIntegrity(desc ocispec.Descriptor) error {
+	if err := sig.verifyHeaders(); err != nil {
+		return err
+	}
+	if len(sig.certs) == 0 {
+		return errors.New("missing the certificate chain")
+	}
+	if err := verifyJOSE(sig.certs[0].PublicKey, sig.alg, sig.signedData, sig.signature); err != nil {
+		return err
+	}
+	var payload notationPayload
+	if err := json.Unmarshal(sig.payload, &payload); err != nil {
+		return fmt.Errorf("failed to parse the payload: %w", err)
+	}
+	target := payload.TargetArtifact
+	if target.Digest != desc.Digest || target.Size != desc.Size || target.MediaType != desc.MediaType {
+		return fmt.Errorf("expected the target artifact to be %s (%s, %d bytes), got %s (%s, %d bytes)",
+			desc.Digest, desc.MediaType, desc.Size, target.Digest, target.MediaType, target.Size)
+	}
+	return nil
+}
+
+// verifyHeaders verifies the protected headers required by the Notary Project signature specification.
+// The critical headers have to be understood, and the signing scheme and the signing time are required.
+func (sig *notationSignature) verifyHeaders() error {
+	if sig.contentType != MediaTypeNotationPayload {
+		return fmt.Errorf("unexpected content type %q", sig.contentType)
+	}
+	crit := make(map[string]bool)
+	for _, c := range sig.crit {
+		switch c {
+		case notationHeaderSigningScheme, notationHeaderExpiry, notationHeaderAuthenticSigningTime:
+			crit[c] = true
+		default:
+			return fmt.Errorf("unsupported critical header %q", c)
+		}
+	}
+	for h, present := range map[string]bool{
+		notationHeaderSigningScheme:        true,
+		notationHeaderExpiry:               !sig.expiry.IsZero(),
+		notationHeaderAuthenticSigningTime: !sig.authenticSigningTime.IsZero(),
+	} {
+		if present && !crit[h] {
+			return fmt.Errorf("%q is not marked critical", h)
+		}
+	}
+	switch sig.signingScheme {
+	case notationSchemeX509:
+		if sig.signingTime.IsZero() {
+			return fmt.Errorf("missing %q", notationHeaderSigningTime)
+		}
+	case notationSchemeX509SigningAuthority:
+		if sig.authenticSigningTime.IsZero() {
+			return fmt.Errorf("missing %q", notationHeaderAuthenticSigningTime)
+		}
+	default:
+		return fmt.Errorf("unsupported signing scheme %q", sig.signingScheme)
+	}
+	return nil
+}
+
+func (v *NotationVerifier) verifyAuthenticity(policy *notationTrustPolicy, sig *notationSignature, verifyTime time.Time) error {
+	storeType := "ca"
+	if sig.signingScheme == notationSchemeX509SigningAuthority {
+		storeType = "signingAuthority"
+	}
+	roots := x509.NewCertPool()
+	var nRoots int
+	for _, ts := range policy.TrustStores {
+		typ, name, ok := strings.Cut(ts, ":")
+		if !ok || typ != storeType {
+			continue
+		}
+		certs, err := loadCertificates(filepath.Join(v.configDir, "truststore", "x509", typ, name))
+		if err != nil {
+			return err
+		}
+		for _, c := range certs {
+			roots.AddCert(c)
+			nRoots++
+		}
+	}
+	if nRoots == 0 {
+		return fmt.Errorf("trust policy %q has no %q trust store certificate", policy.Name, storeType)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range sig.certs[1:] {
+		intermediates.AddCert(c)
+	}
+	leaf := sig.certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("failed to verify the certificate chain: %w", err)
+	}
+	for _, identity := range policy.TrustedIdentities {
+		if identity == "*" {
+			return nil
+		}
+		if dn, ok := strings.CutPrefix(identity, "x509.subject:"); ok && isSubsetDN(parseDN(dn), parseDN(leaf.Subject.String())) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the signing certificate %q is not a trusted identity of trust policy %q", leaf.Subject, policy.Name)
+}
+
+// loadCertificates loads PEM or DER certificates in the directory.
+func loadCertificates(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, ent := range entries {
+		if ent.IsDir() {
+			continue
+		}
+		f := filepath.Join(dir, ent.Name())
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(string(b), "-----BEGIN") {
+			der, err := x509.ParseCertificates(b)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", f, err)
+			}
+			certs = append(certs, der...)
+			continue
+		}
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", f, err)
+			}
+			certs = append(certs, c)
+		}
+	}
+	return certs, nil
+}
+
+// parseDN parses a distinguished name like "C=US, ST=WA, O=example.com".
+func parseDN(s string) map[string]string {
+	dn := make(map[string]string)
+	var attr strings.Builder
+	flush := func() {
+		if k, v, ok := strings.Cut(attr.String(), "="); ok {
+			dn[strings.ToUpper(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+		attr.Reset()
+	}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			attr.WriteByte(s[i])
+		case s[i] == ',' || s[i] == '+':
+			flush()
+		default:
+			attr.WriteByte(s[i])
+		}
+	}
+	flush()
+	return dn
+}
+
+// isSubsetDN returns true if all the attributes of a are in b.
+func isSubsetDN(a, b map[string]string) bool {
+	if len(a) == 0 {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyJOSE verifies sig with the JWS algorithm.
+// Only the algorithms allowed by the Notary Project signature specification are supported.
+func verifyJOSE(pub crypto.PublicKey, alg string, signedData, sig []byte) error {
+	var (
+		h   crypto.Hash
+		pss bool
+	)
+	switch alg {
+	case "PS256":
+		h, pss = crypto.SHA256, true
+	case "PS384":
+		h, pss = crypto.SHA384, true
+	case "PS512":
+		h, pss = crypto.SHA512, true
+	case "ES256":
+		h = crypto.SHA256
+	case "ES384":
+		h = crypto.SHA384
+	case "ES512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	hasher := h.New()
+	hasher.Write(signedData)
+	digest := hasher.Sum(nil)
+	if pss {
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("expected an RSA key for %q, got %T", alg, pub)
+		}
+		if err := rsa.VerifyPSS(k, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+		return nil
+	}
+	k, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("expected an ECDSA key for %q, got %T", alg, pub)
+	}
+	if hashForPublicKey(k) != h {
+		return fmt.Errorf("the curve %s cannot be used for %q", k.Curve.Params().Name, alg)
+	}
+	// r || s
+	n := (k.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*n {
+		return errors.New("invalid ECDSA signature length")
+	}
+	r, s := new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])
+	if !ecdsa.Verify(k, digest, r, s) {
+		return errors.New("invalid ECDSA signature")
+	}
+	return nil
+}
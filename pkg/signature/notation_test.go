@@ -0,0 +1,302 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	refdocker "github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type notationTestEnv struct {
+	configDir string
+	leafKey   *ecdsa.PrivateKey
+	chain     [][]byte
+}
+
+func newNotationTestEnv(t *testing.T, trustedIdentity string) *notationTestEnv {
+	now := time.Now()
+	caKey := newTestKey(t)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey := newTestKey(t)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{Country: []string{"US"}, Organization: []string{"example.com"}, CommonName: "signer"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caTmpl, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configDir := t.TempDir()
+	storeDir := filepath.Join(configDir, "truststore", "x509", "ca", "example")
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if err := os.WriteFile(filepath.Join(storeDir, "ca.pem"), caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policy := fmt.Sprintf(`{"version":"1.0","trustPolicies":[
+{"name":"example","registryScopes":["ghcr.io/foo/bar"],"signatureVerification":{"level":"strict"},"trustStores":["ca:example"],"trustedIdentities":[%q]},
+{"name":"others","registryScopes":["*"],"signatureVerification":{"level":"skip"}}]}`, trustedIdentity)
+	if err := os.WriteFile(filepath.Join(configDir, "trustpolicy.json"), []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &notationTestEnv{
+		configDir: configDir,
+		leafKey:   leafKey,
+		chain:     [][]byte{leafDER, caDER},
+	}
+}
+
+// signRaw signs message with ES256, in the r || s format.
+func (env *notationTestEnv) signRaw(t *testing.T, message []byte) []byte {
+	h := sha256.Sum256(message)
+	r, s, err := ecdsa.Sign(rand.Reader, env.leafKey, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig
+}
+
+func (env *notationTestEnv) jws(t *testing.T, payload []byte) []byte {
+	protected, err := json.Marshal(map[string]interface{}{
+		"alg":                         "ES256",
+		"cty":                         MediaTypeNotationPayload,
+		"crit":                        []string{notationHeaderSigningScheme},
+		notationHeaderSigningScheme:   notationSchemeX509,
+		notationHeaderSigningTime:     time.Now().Format(time.RFC3339),
+		"io.cncf.notary.signingAgent": "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	protectedB64 := base64.RawURLEncoding.EncodeToString(protected)
+	payloadB64 := base64.RawURLEncoding.EncodeToString(payload)
+	b, err := json.Marshal(map[string]interface{}{
+		"payload":   payloadB64,
+		"protected": protectedB64,
+		"header":    map[string]interface{}{"x5c": env.chain},
+		"signature": base64.RawURLEncoding.EncodeToString(env.signRaw(t, []byte(protectedB64+"."+payloadB64))),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (env *notationTestEnv) cose(t *testing.T, payload []byte) []byte {
+	protected, err := cborEncode(map[interface{}]interface{}{
+		int64(coseHeaderAlg):        int64(-7),
+		int64(coseHeaderCrit):       []interface{}{notationHeaderSigningScheme},
+		int64(coseHeaderCty):        MediaTypeNotationPayload,
+		notationHeaderSigningScheme: notationSchemeX509,
+		notationHeaderSigningTime:   cborTag{Number: cborTagEpochSeconds, Content: time.Now().Unix()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigStructure, err := cborEncode([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := make([]interface{}, len(env.chain))
+	for i, c := range env.chain {
+		chain[i] = c
+	}
+	b, err := cborEncode(cborTag{Number: coseTagSign1, Content: []interface{}{
+		protected,
+		map[interface{}]interface{}{int64(coseHeaderX5Chain): chain},
+		payload,
+		env.signRaw(t, sigStructure),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func addNotationSignature(t *testing.T, reg *memRegistry, subject ocispec.Descriptor, envMediaType string, env []byte) {
+	envDesc := reg.add(envMediaType, env)
+	emptyDesc := reg.add(ocispec.MediaTypeEmptyJSON, []byte("{}"))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: ArtifactTypeNotationSignature,
+		Config:       emptyDesc,
+		Layers:       []ocispec.Descriptor{envDesc},
+		Subject:      &subject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := reg.add(ocispec.MediaTypeImageManifest, manifest)
+	desc.ArtifactType = ArtifactTypeNotationSignature
+	reg.referrers[subject.Digest] = append(reg.referrers[subject.Digest], desc)
+}
+
+func TestNotationVerifier(t *testing.T) {
+	ctx := context.Background()
+	ref, err := refdocker.ParseDockerRef("ghcr.io/foo/bar:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name            string
+		trustedIdentity string
+		envMediaType    string
+		targetDigest    func(ocispec.Descriptor) digest.Digest
+		expectedError   bool
+	}{
+		{name: "jws", trustedIdentity: "x509.subject: C=US, O=example.com", envMediaType: MediaTypeJWS},
+		{name: "cose", trustedIdentity: "*", envMediaType: MediaTypeCOSE},
+		{name: "untrusted-identity", trustedIdentity: "x509.subject: O=example.org", envMediaType: MediaTypeJWS, expectedError: true},
+		{name: "wrong-target", trustedIdentity: "*", envMediaType: MediaTypeCOSE, expectedError: true,
+			targetDigest: func(ocispec.Descriptor) digest.Digest { return digest.FromString("x") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := newMemRegistry()
+			rootDesc := reg.add(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+			env := newNotationTestEnv(t, tc.trustedIdentity)
+			v, err := NewNotationVerifier(env.configDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := Target{Ref: ref, Desc: rootDesc, Resolver: reg, Fetcher: reg}
+			if err := v.Verify(ctx, target); err == nil {
+				t.Fatal("expected an error for the unsigned artifact")
+			}
+
+			signed := rootDesc
+			if tc.targetDigest != nil {
+				signed.Digest = tc.targetDigest(rootDesc)
+			}
+			payload, err := json.Marshal(notationPayload{TargetArtifact: signed})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var envelope []byte
+			if tc.envMediaType == MediaTypeJWS {
+				envelope = env.jws(t, payload)
+			} else {
+				envelope = env.cose(t, payload)
+			}
+			addNotationSignature(t, reg, rootDesc, tc.envMediaType, envelope)
+			err = v.Verify(ctx, target)
+			if tc.expectedError && err == nil {
+				t.Fatal("expected an error")
+			} else if !tc.expectedError && err != nil {
+				t.Fatal(err)
+			}
+
+			// "*" has the level "skip"
+			otherRef, err := refdocker.ParseDockerRef("ghcr.io/foo/baz:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Verify(ctx, Target{Ref: otherRef, Desc: rootDesc, Resolver: reg, Fetcher: reg}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNotationSignatureHeaders(t *testing.T) {
+	now := time.Now()
+	valid := func() *notationSignature {
+		return &notationSignature{
+			contentType:   MediaTypeNotationPayload,
+			crit:          []string{notationHeaderSigningScheme},
+			signingScheme: notationSchemeX509,
+			signingTime:   now,
+		}
+	}
+	for _, tc := range []struct {
+		name          string
+		mutate        func(*notationSignature)
+		expectedError bool
+	}{
+		{name: "valid", mutate: func(*notationSignature) {}},
+		{name: "expiry", mutate: func(sig *notationSignature) {
+			sig.expiry = now.Add(time.Hour)
+			sig.crit = append(sig.crit, notationHeaderExpiry)
+		}},
+		{name: "unknown-crit", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.crit = append(sig.crit, "io.cncf.notary.unknown")
+		}},
+		{name: "cose-label-crit", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.crit = append(sig.crit, "1")
+		}},
+		{name: "non-critical-signing-scheme", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.crit = nil
+		}},
+		{name: "non-critical-expiry", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.expiry = now.Add(time.Hour)
+		}},
+		{name: "missing-signing-time", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.signingTime = time.Time{}
+		}},
+		{name: "missing-authentic-signing-time", expectedError: true, mutate: func(sig *notationSignature) {
+			sig.signingScheme = notationSchemeX509SigningAuthority
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := valid()
+			tc.mutate(sig)
+			err := sig.verifyHeaders()
+			if tc.expectedError && err == nil {
+				t.Fatal("expected an error")
+			} else if !tc.expectedError && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVerifyJOSEAlg(t *testing.T) {
+	key := newTestKey(t)
+	env := &notationTestEnv{leafKey: key}
+	signedData := []byte("signed data")
+	sig := env.signRaw(t, signedData)
+	if err := verifyJOSE(key.Public(), "ES256", signedData, sig); err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{"ESE256", "ESP256", "EES256", "256", "EdDSA"} {
+		if err := verifyJOSE(key.Public(), alg, signedData, sig); err == nil {
+			t.Errorf("expected %q to be rejected", alg)
+		}
+	}
+}
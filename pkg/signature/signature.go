@@ -0,0 +1,126 @@
+// Package signature verifies the signatures of the repository artifacts.
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	refdocker "github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Target is the artifact to be verified.
+type Target struct {
+	// Ref is the reference of the repository
+	Ref refdocker.Named
+	// Desc is the root descriptor of the artifact
+	Desc     ocispec.Descriptor
+	Resolver remotes.Resolver
+	// Fetcher is the fetcher for the repository of Ref
+	Fetcher remotes.Fetcher
+	// Warnf is called for the failures that are logged but not enforced (optional)
+	Warnf func(format string, args ...interface{})
+}
+
+func (t Target) warnf(format string, args ...interface{}) {
+	if t.Warnf != nil {
+		t.Warnf(format, args...)
+	}
+}
+
+// Verifier verifies the signature of the artifact.
+type Verifier interface {
+	// Name returns the name of the verifier, such as "cosign".
+	Name() string
+	// Verify returns nil if the artifact has a valid signature.
+	Verify(ctx context.Context, t Target) error
+}
+
+// LoadPublicKeys loads PEM-encoded public keys ("PUBLIC KEY").
+func LoadPublicKeys(files []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			if block.Type != "PUBLIC KEY" {
+				continue
+			}
+			k, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key in %q: %w", f, err)
+			}
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public key was loaded")
+	}
+	return keys, nil
+}
+
+// hashForPublicKey returns the hash algorithm for the key, following the defaults of Sigstore.
+func hashForPublicKey(pub crypto.PublicKey) crypto.Hash {
+	if k, ok := pub.(*ecdsa.PublicKey); ok {
+		switch k.Curve {
+		case elliptic.P384():
+			return crypto.SHA384
+		case elliptic.P521():
+			return crypto.SHA512
+		}
+	}
+	return crypto.SHA256
+}
+
+// verifyMessage verifies sig over message.
+func verifyMessage(pub crypto.PublicKey, message, sig []byte) error {
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(k, message, sig) {
+			return errors.New("invalid ed25519 signature")
+		}
+		return nil
+	}
+	h := hashForPublicKey(pub)
+	hasher := h.New()
+	hasher.Write(message)
+	return verifyDigest(pub, h, hasher.Sum(nil), sig)
+}
+
+// verifyDigest verifies sig over the digest of a message.
+func verifyDigest(pub crypto.PublicKey, h crypto.Hash, digest, sig []byte) error {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, h, digest, sig); err != nil {
+			if errPSS := rsa.VerifyPSS(k, h, digest, sig, nil); errPSS != nil {
+				return fmt.Errorf("invalid RSA signature: %w", err)
+			}
+		}
+		return nil
+	case ed25519.PublicKey:
+		return errors.New("ed25519 keys cannot verify a pre-hashed message")
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}